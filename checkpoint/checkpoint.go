@@ -0,0 +1,243 @@
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moritz-tiesler/sous/tools"
+)
+
+// Checkpoint holds the content files had before the tools changed them
+// during one turn of the conversation.
+type Checkpoint struct {
+	Turn  int
+	Label string
+	Time  time.Time
+	// ConversationLen is the length of the conversation before the turn
+	// started, -1 if the conversation was summarized since.
+	ConversationLen int
+
+	files []snapshot
+}
+
+type snapshot struct {
+	path    string // absolute
+	existed bool
+	content []byte
+}
+
+// Files returns the paths of the files changed in the turn.
+func (c *Checkpoint) Files() []string {
+	paths := make([]string, 0, len(c.files))
+	for _, f := range c.files {
+		paths = append(paths, display(f.path))
+	}
+	return paths
+}
+
+func (c *Checkpoint) find(path string) (snapshot, bool) {
+	for _, f := range c.files {
+		if f.path == path {
+			return f, true
+		}
+	}
+	return snapshot{}, false
+}
+
+// Store keeps the checkpoints of a session in memory, oldest first.
+type Store struct {
+	mu          sync.Mutex
+	checkpoints []*Checkpoint
+	nextTurn    int
+}
+
+func NewStore() *Store {
+	return &Store{nextTurn: 1}
+}
+
+// Begin starts the checkpoint of a new turn. label describes the turn,
+// usually the message of the user.
+func (s *Store) Begin(label string, conversationLen int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &Checkpoint{
+		Turn:            s.nextTurn,
+		Label:           label,
+		Time:            time.Now(),
+		ConversationLen: conversationLen,
+	}
+	s.nextTurn++
+	s.checkpoints = append(s.checkpoints, c)
+	return c.Turn
+}
+
+// Record saves the content a file had before change, unless the file was
+// already changed earlier in the same turn.
+func (s *Store) Record(change tools.FileChange) {
+	path, err := filepath.Abs(change.Path)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.checkpoints) == 0 {
+		// changes before the first turn
+		s.checkpoints = append(s.checkpoints, &Checkpoint{Turn: 0, Time: time.Now(), ConversationLen: 0})
+	}
+	c := s.checkpoints[len(s.checkpoints)-1]
+	if _, ok := c.find(path); ok {
+		return
+	}
+	c.files = append(c.files, snapshot{path: path, existed: change.Existed, content: change.Before})
+}
+
+// Compacted marks that the conversation was replaced by a summary, so
+// earlier checkpoints can no longer rewind it.
+func (s *Store) Compacted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.checkpoints {
+		c.ConversationLen = -1
+	}
+}
+
+// List returns all checkpoints, oldest first.
+func (s *Store) List() []Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Checkpoint, 0, len(s.checkpoints))
+	for _, c := range s.checkpoints {
+		list = append(list, *c)
+	}
+	return list
+}
+
+// LastChanged returns the latest turn that changed files, or false if no
+// turn did.
+func (s *Store) LastChanged() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.checkpoints) - 1; i >= 0; i-- {
+		if len(s.checkpoints[i].files) > 0 {
+			return s.checkpoints[i].Turn, true
+		}
+	}
+	return 0, false
+}
+
+// Get returns the checkpoint of turn.
+func (s *Store) Get(turn int) (Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.since(turn)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	return *s.checkpoints[i], nil
+}
+
+// since returns the index of the checkpoint of turn.
+func (s *Store) since(turn int) (int, error) {
+	for i, c := range s.checkpoints {
+		if c.Turn == turn {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("there is no checkpoint for turn %d", turn)
+}
+
+// originals returns the content every file changed since turn had before
+// that turn, in the order the files were first changed.
+func (s *Store) originals(from int) []snapshot {
+	seen := map[string]bool{}
+	files := []snapshot{}
+	for _, c := range s.checkpoints[from:] {
+		for _, f := range c.files {
+			if !seen[f.path] {
+				seen[f.path] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files
+}
+
+// Diff returns a unified diff of all files changed since the start of
+// turn against their current content.
+func (s *Store) Diff(turn int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.since(turn)
+	if err != nil {
+		return "", err
+	}
+	out := ""
+	for _, f := range s.originals(i) {
+		current, err := os.ReadFile(f.path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		out += tools.UnifiedDiff(display(f.path), string(f.content), string(current))
+	}
+	return out, nil
+}
+
+// Restore puts every file changed since the start of turn back into the
+// state it had before the turn and drops the checkpoints of turn and all
+// later turns. It returns the dropped checkpoint of turn and the diffs of
+// the restored files.
+func (s *Store) Restore(turn int) (Checkpoint, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.since(turn)
+	if err != nil {
+		return Checkpoint{}, nil, err
+	}
+	diffs := []string{}
+	for _, f := range s.originals(i) {
+		current, err := os.ReadFile(f.path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return Checkpoint{}, diffs, err
+		}
+		if err := f.restore(); err != nil {
+			return Checkpoint{}, diffs, err
+		}
+		diffs = append(diffs, tools.UnifiedDiff(display(f.path), string(current), string(f.content)))
+	}
+	restored := *s.checkpoints[i]
+	s.checkpoints = s.checkpoints[:i]
+	return restored, diffs, nil
+}
+
+func (f snapshot) restore() error {
+	if !f.existed {
+		err := os.Remove(f.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, f.content, 0644)
+}
+
+// display shortens path relative to the working directory.
+func display(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moritz-tiesler/sous/command"
+)
+
+// checkpointCommands are the REPL commands for checkpoints.
+func (a *Agent) checkpointCommands() []command.Command {
+	return []command.Command{
+		{
+			Name:        "checkpoints",
+			Description: "list the turns and the files they changed",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				a.listCheckpoints()
+				return command.Result{}, nil
+			},
+		},
+		{
+			Name:        "diff",
+			Usage:       "[turn]",
+			Description: "show the changes made since the start of turn",
+			Run:         a.diffCheckpoint,
+		},
+		{
+			Name:        "undo",
+			Usage:       "[turn]",
+			Description: "restore the files to their state before turn",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				return command.Result{}, a.restoreCheckpoint(args, false)
+			},
+		},
+		{
+			Name:        "rewind",
+			Usage:       "[turn]",
+			Description: "like /undo, and drop the conversation from turn on",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				return command.Result{}, a.restoreCheckpoint(args, true)
+			},
+		},
+	}
+}
+
+func (a *Agent) diffCheckpoint(ctx context.Context, args string) (command.Result, error) {
+	turn, ok := a.checkpointTurn(args, true)
+	if !ok {
+		return command.Result{}, nil
+	}
+	diff, err := a.checkpoints.Diff(turn)
+	if err != nil {
+		return command.Result{}, err
+	}
+	if diff == "" {
+		PrintAction("No changes since turn %d\n", turn)
+		return command.Result{}, nil
+	}
+	PrintDiff(diff)
+	return command.Result{}, nil
+}
+
+// restoreCheckpoint restores the files to their state before a turn. With
+// rewind the conversation is also cut back to where the turn started.
+func (a *Agent) restoreCheckpoint(args string, rewind bool) error {
+	turn, ok := a.checkpointTurn(args, !rewind)
+	if !ok {
+		return nil
+	}
+	// check before touching the files, Restore drops the checkpoints
+	if rewind {
+		c, err := a.checkpoints.Get(turn)
+		if err != nil {
+			return err
+		}
+		if c.ConversationLen < 0 || c.ConversationLen > len(a.conversation) {
+			return fmt.Errorf("the conversation was summarized or cleared since turn %d and cannot be rewound, /undo %d restores the files only", turn, turn)
+		}
+	}
+	restored, diffs, err := a.checkpoints.Restore(turn)
+	for _, diff := range diffs {
+		PrintDiff(diff)
+	}
+	if err != nil {
+		return fmt.Errorf("restoring turn %d failed: %w", turn, err)
+	}
+	PrintAction("Restored %d file(s) to their state before turn %d\n", len(diffs), turn)
+	if !rewind {
+		return nil
+	}
+	a.conversation = a.conversation[:restored.ConversationLen]
+	a.history.Truncated(len(a.conversation))
+	if a.session != nil {
+		a.session.Reset(len(a.conversation))
+	}
+	PrintAction("Rewound the conversation to before: %s\n", restored.Label)
+	return nil
+}
+
+func (a *Agent) listCheckpoints() {
+	list := a.checkpoints.List()
+	if len(list) == 0 {
+		PrintAction("No checkpoints yet\n")
+		return
+	}
+	for _, c := range list {
+		label := c.Label
+		if len(label) > 60 {
+			label = label[:60] + "..."
+		}
+		files := "no file changes"
+		if f := c.Files(); len(f) > 0 {
+			files = strings.Join(f, ", ")
+		}
+		PrintAction("%3d  %s  %q\n", c.Turn, c.Time.Format("15:04:05"), label)
+		fmt.Printf("     %s\n", files)
+	}
+}
+
+// checkpointTurn parses the turn argument of a checkpoint command. Without
+// an argument it picks the last turn, or the last turn that changed files
+// if changed is set.
+func (a *Agent) checkpointTurn(args string, changed bool) (int, bool) {
+	if fields := strings.Fields(args); len(fields) > 0 {
+		turn, err := strconv.Atoi(fields[0])
+		if err != nil {
+			PrintAction("invalid turn %q, see /checkpoints\n", fields[0])
+			return 0, false
+		}
+		return turn, true
+	}
+	if changed {
+		turn, ok := a.checkpoints.LastChanged()
+		if !ok {
+			PrintAction("No file changes to undo\n")
+		}
+		return turn, ok
+	}
+	list := a.checkpoints.List()
+	if len(list) == 0 {
+		PrintAction("No checkpoints yet\n")
+		return 0, false
+	}
+	return list[len(list)-1].Turn, true
+}
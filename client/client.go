@@ -2,12 +2,12 @@ package client
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/moritz-tiesler/sous/config"
 	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
 )
 
 type ChatContext struct {
@@ -16,10 +16,23 @@ type ChatContext struct {
 }
 
 type Client struct {
-	c           *openai.Client
-	modelName   string
+	// providers are the primary endpoint followed by the fallbacks, in
+	// the order they are tried
+	providers   []Provider
 	mu          sync.Mutex
 	ChatContext *ChatContext
+	lastUsage   Usage
+
+	// MaxRetries is the number of times a transient failure is retried
+	// on the same endpoint before failing over to the next one.
+	MaxRetries int
+	// Log receives a line for every failed attempt and every failover.
+	Log func(format string, args ...any)
+
+	// active is the endpoint requests start with, failedOver is the time
+	// the client switched to it from the primary one
+	active     int
+	failedOver time.Time
 }
 
 func (c *Client) SetActiveChatContext(ctx context.Context, cancel context.CancelFunc) {
@@ -33,62 +46,84 @@ func (c *Client) ClearChatContext() {
 }
 
 // todo inherit context, ie pass the context to New
-func New(modelName string) *Client {
-	c := openai.NewClient(
-		option.WithBaseURL("http://172.26.208.1:1234/v1/"),
-	)
+func New(cfg *config.Config) (*Client, error) {
+	endpoints := append([]config.Endpoint{{Model: cfg.Model}}, cfg.Fallbacks...)
+	providers := []Provider{}
+	for _, e := range endpoints {
+		provider, err := NewProvider(cfg, e)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
 	return &Client{
-		c:           &c,
-		modelName:   modelName,
+		providers:   providers,
 		ChatContext: &ChatContext{},
-	}
+		MaxRetries:  cfg.MaxRetries,
+	}, nil
 }
 
 func (c *Client) RunInference(
 	ctx context.Context,
 	conversation []openai.ChatCompletionMessageParamUnion,
-	tools []openai.ChatCompletionToolParam,
+	tools ToolSet,
+) (openai.ChatCompletionMessage, error) {
+	return c.RunInferenceStream(ctx, conversation, tools, nil)
+}
+
+// RunInferenceStream behaves like RunInference but streams the response.
+// onDelta is called with every content fragment as it arrives, tool call
+// fragments are accumulated into the returned message. A retried request
+// streams its content again. Errors are of type *Error, a response without
+// content and tool calls is an error of KindEmptyResponse.
+func (c *Client) RunInferenceStream(
+	ctx context.Context,
+	conversation []openai.ChatCompletionMessageParamUnion,
+	tools ToolSet,
+	onDelta func(string),
 ) (openai.ChatCompletionMessage, error) {
 	reqCtx, reqCancel := context.WithCancel(ctx)
 	c.SetActiveChatContext(reqCtx, reqCancel)
-	chatCompletion, err := c.c.Chat.Completions.New(reqCtx, openai.ChatCompletionNewParams{
-		Messages: conversation,
-		Model:    c.modelName,
-		Tools:    tools,
-	})
-
-	var message openai.ChatCompletionMessage
-	if err != nil {
-		if errors.Is(err, context.Canceled) {
-			return message, fmt.Errorf("inference cancelled: %v", err)
-		}
-		return message, err
-	}
-	c.ClearChatContext()
-	return chatCompletion.Choices[0].Message, nil
+	defer c.ClearChatContext()
+
+	message, usage, err := c.chat(reqCtx, conversation, tools, onDelta)
+	c.mu.Lock()
+	c.lastUsage = usage
+	c.mu.Unlock()
+	return message, err
+}
+
+// LastUsage returns the token usage of the latest chat request.
+func (c *Client) LastUsage() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastUsage
+}
+
+// Model returns the model of the primary endpoint.
+func (c *Client) Model() string {
+	return c.providers[0].Model()
+}
+
+// SetModel switches the model of the primary endpoint, which is used for
+// the following requests again.
+func (c *Client) SetModel(name string) {
+	c.providers[0].SetModel(name)
+	c.active = 0
 }
 
 func (c *Client) RunInferenceSingle(
 	ctx context.Context,
 	prompt string,
 ) (string, error) {
+	p, ok := c.providers[0].(*openAIProvider)
+	if !ok {
+		return "", fmt.Errorf("single completions are only supported by the openai provider")
+	}
 	reqCtx, reqCancel := context.WithCancel(ctx)
 	c.SetActiveChatContext(reqCtx, reqCancel)
-	completion, err := c.c.Completions.New(reqCtx, openai.CompletionNewParams{
-		Prompt: openai.CompletionNewParamsPromptUnion{
-			OfString: openai.String(prompt),
-		},
-
-		Model: openai.CompletionNewParamsModel(c.modelName),
-	})
-
-	var message string
-	if err != nil {
-		if errors.Is(err, context.Canceled) {
-			return message, fmt.Errorf("inference cancelled: %v", err)
-		}
-		return message, err
-	}
-	c.ClearChatContext()
-	return completion.Choices[0].Text, nil
+	defer c.ClearChatContext()
+
+	text, err := p.complete(reqCtx, prompt)
+	return text, classify(reqCtx, err)
 }
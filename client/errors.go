@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go"
+)
+
+// Kind classifies why a request failed, so the agent can decide whether to
+// retry it.
+type Kind int
+
+const (
+	// KindRequest is any other failure, e.g. a bad request or a missing
+	// API key. Retrying does not help.
+	KindRequest Kind = iota
+	// KindCancelled means the request was cancelled, e.g. by the user.
+	KindCancelled
+	// KindNetwork means the server could not be reached or the connection
+	// broke.
+	KindNetwork
+	// KindRateLimited means the server asked to slow down.
+	KindRateLimited
+	// KindContextLength means the conversation does not fit into the
+	// context window of the model.
+	KindContextLength
+	// KindServer means the server failed to handle the request.
+	KindServer
+	// KindEmptyResponse means the model answered with neither content
+	// nor tool calls.
+	KindEmptyResponse
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindCancelled:
+		return "cancelled"
+	case KindNetwork:
+		return "network"
+	case KindRateLimited:
+		return "rate limited"
+	case KindContextLength:
+		return "context length exceeded"
+	case KindServer:
+		return "server error"
+	case KindEmptyResponse:
+		return "empty response"
+	}
+	return "request failed"
+}
+
+// Transient reports whether a failure of this kind may go away when the
+// request is repeated.
+func (k Kind) Transient() bool {
+	switch k {
+	case KindNetwork, KindRateLimited, KindServer, KindEmptyResponse:
+		return true
+	}
+	return false
+}
+
+// Error is the error of a failed request.
+type Error struct {
+	Kind Kind
+	// StatusCode is the HTTP status of the response, 0 if there was none.
+	StatusCode int
+	// RetryAfter is the delay the server asked for, 0 if it did not.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.String()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err.Error())
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of a request error, KindRequest if err was not
+// returned by the client.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	if errors.Is(err, context.Canceled) {
+		return KindCancelled
+	}
+	return KindRequest
+}
+
+// errEmptyResponse is returned for responses without content or tool
+// calls.
+var errEmptyResponse = &Error{Kind: KindEmptyResponse}
+
+// classify wraps an error of a provider into an *Error.
+func classify(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	e = &Error{Kind: KindRequest, Err: err}
+	var apiErr *openai.Error
+	var statusErr api.StatusError
+	var netErr net.Error
+	switch {
+	case ctx.Err() != nil && errors.Is(err, context.Canceled):
+		e.Kind = KindCancelled
+	case errors.As(err, &apiErr):
+		e.StatusCode = apiErr.StatusCode
+		var header http.Header
+		if apiErr.Response != nil {
+			header = apiErr.Response.Header
+		}
+		e.Kind, e.RetryAfter = kindOfStatus(apiErr.StatusCode, apiErr.Message+" "+apiErr.RawJSON(), header)
+	case errors.As(err, &statusErr):
+		e.StatusCode = statusErr.StatusCode
+		e.Kind, _ = kindOfStatus(statusErr.StatusCode, statusErr.ErrorMessage, nil)
+	case contextLengthMessage(err.Error()):
+		e.Kind = KindContextLength
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET):
+		e.Kind = KindNetwork
+	case strings.Contains(err.Error(), "error while streaming"):
+		// the server reported an error in the middle of the stream
+		e.Kind = KindServer
+	}
+	return e
+}
+
+func kindOfStatus(status int, message string, header http.Header) (Kind, time.Duration) {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return KindRateLimited, retryAfter(header)
+	case contextLengthMessage(message):
+		return KindContextLength, 0
+	case status == http.StatusRequestEntityTooLarge:
+		return KindContextLength, 0
+	case status == http.StatusRequestTimeout:
+		return KindNetwork, 0
+	case status >= 500:
+		return KindServer, retryAfter(header)
+	}
+	return KindRequest, 0
+}
+
+// contextLengthMessage recognizes the ways servers report a conversation
+// that is too long for the model.
+func contextLengthMessage(message string) bool {
+	message = strings.ToLower(message)
+	for _, s := range []string{
+		"context_length_exceeded",
+		"context length",
+		"context window",
+		"maximum context",
+		"context overflow",
+		"exceeds the context",
+		"too many tokens",
+		"prompt is too long",
+	} {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header, given either in seconds or as
+// a date.
+func retryAfter(header http.Header) time.Duration {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// WireMessage is the common shape of all openai message params, as they
+// are sent to the server, with the content flattened to text.
+type WireMessage struct {
+	Role      string
+	Content   string
+	ToolCalls []WireToolCall
+}
+
+// WireToolCall is a tool call of an assistant message.
+type WireToolCall struct {
+	Name      string
+	Arguments string
+}
+
+// DecodeMessage converts a message param of any role into a WireMessage.
+func DecodeMessage(m openai.ChatCompletionMessageParamUnion) (WireMessage, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return WireMessage{}, err
+	}
+	var w struct {
+		Role      string          `json:"role"`
+		Content   json.RawMessage `json:"content"`
+		ToolCalls []struct {
+			Function struct {
+				Name      string `json:"name"`
+				Arguments string `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return WireMessage{}, err
+	}
+	message := WireMessage{Role: w.Role, Content: contentText(w.Content)}
+	for _, tc := range w.ToolCalls {
+		message.ToolCalls = append(message.ToolCalls, WireToolCall{
+			Name:      tc.Function.Name,
+			Arguments: tc.Function.Arguments,
+		})
+	}
+	return message, nil
+}
+
+// contentText flattens message content, which is either a plain string or
+// a list of text parts.
+func contentText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(raw, &parts) != nil {
+		return ""
+	}
+	sb := strings.Builder{}
+	for _, p := range parts {
+		sb.WriteString(p.Text)
+	}
+	return sb.String()
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/moritz-tiesler/sous/config"
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/openai/openai-go"
+)
+
+// ollamaProvider talks to the native ollama chat API.
+type ollamaProvider struct {
+	c         *api.Client
+	modelName string
+	host      string
+}
+
+func newOllamaProvider(cfg *config.Config, endpoint config.Endpoint) (*ollamaProvider, error) {
+	headers := map[string]string{}
+	for k, v := range cfg.Headers {
+		headers[k] = v
+	}
+	if cfg.APIKey != "" {
+		headers["Authorization"] = "Bearer " + cfg.APIKey
+	}
+	httpClient := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: headerTransport{headers: headers, next: http.DefaultTransport},
+	}
+
+	base := envconfig.Host()
+	baseURL := endpoint.BaseURL
+	if baseURL == "" && cfg.Source("base_url") != "default" {
+		baseURL = cfg.BaseURL
+	}
+	// the default base url points to an OpenAI compatible server, use
+	// OLLAMA_HOST unless a base url was given
+	if baseURL != "" {
+		var err error
+		base, err = url.Parse(strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base url: %w", err)
+		}
+	}
+	return &ollamaProvider{
+		c:         api.NewClient(base, httpClient),
+		modelName: endpoint.Model,
+		host:      base.String(),
+	}, nil
+}
+
+func (p *ollamaProvider) Model() string {
+	return p.modelName
+}
+
+func (p *ollamaProvider) SetModel(name string) {
+	p.modelName = name
+}
+
+func (p *ollamaProvider) Name() string {
+	return p.modelName + "@" + p.host
+}
+
+func (p *ollamaProvider) Chat(
+	ctx context.Context,
+	conversation []openai.ChatCompletionMessageParamUnion,
+	tools ToolSet,
+	onDelta func(string),
+) (openai.ChatCompletionMessage, Usage, error) {
+	var message openai.ChatCompletionMessage
+	var usage Usage
+	messages, err := toOllamaMessages(conversation)
+	if err != nil {
+		return message, usage, err
+	}
+	stream := onDelta != nil
+	req := &api.ChatRequest{
+		Model:    p.modelName,
+		Messages: messages,
+		Stream:   &stream,
+	}
+	if tools != nil {
+		req.Tools = tools.Ollama()
+	}
+
+	content := strings.Builder{}
+	toolCalls := []api.ToolCall{}
+	respFunc := func(cr api.ChatResponse) error {
+		if onDelta != nil && cr.Message.Content != "" {
+			onDelta(cr.Message.Content)
+		}
+		content.WriteString(cr.Message.Content)
+		toolCalls = append(toolCalls, cr.Message.ToolCalls...)
+		if cr.Done {
+			usage.PromptTokens = cr.PromptEvalCount
+			usage.CompletionTokens = cr.EvalCount
+		}
+		return nil
+	}
+	if err := p.c.Chat(ctx, req, respFunc); err != nil {
+		return message, usage, err
+	}
+
+	message.Role = "assistant"
+	message.Content = content.String()
+	for i, tc := range toolCalls {
+		args, err := json.Marshal(tc.Function.Arguments)
+		if err != nil {
+			return message, usage, err
+		}
+		message.ToolCalls = append(message.ToolCalls, openai.ChatCompletionMessageToolCall{
+			// ollama does not assign ids to tool calls
+			ID: fmt.Sprintf("call_%d", i),
+			Function: openai.ChatCompletionMessageToolCallFunction{
+				Name:      tc.Function.Name,
+				Arguments: string(args),
+			},
+		})
+	}
+	return message, usage, nil
+}
+
+func toOllamaMessages(conversation []openai.ChatCompletionMessageParamUnion) ([]api.Message, error) {
+	messages := make([]api.Message, 0, len(conversation))
+	for _, m := range conversation {
+		w, err := DecodeMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		msg := api.Message{Role: w.Role, Content: w.Content}
+		for i, tc := range w.ToolCalls {
+			args := api.ToolCallFunctionArguments{}
+			if tc.Arguments != "" {
+				if err := json.Unmarshal([]byte(tc.Arguments), &args); err != nil {
+					return nil, fmt.Errorf("tool call arguments: %w", err)
+				}
+			}
+			msg.ToolCalls = append(msg.ToolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{
+					Index:     i,
+					Name:      tc.Name,
+					Arguments: args,
+				},
+			})
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+type headerTransport struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) == 0 {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+
+	"github.com/moritz-tiesler/sous/config"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// openAIProvider talks to any OpenAI compatible endpoint, e.g. LM Studio.
+type openAIProvider struct {
+	c         *openai.Client
+	modelName string
+	baseURL   string
+}
+
+func newOpenAIProvider(cfg *config.Config, endpoint config.Endpoint) *openAIProvider {
+	baseURL := cmp.Or(endpoint.BaseURL, cfg.BaseURL)
+	opts := []option.RequestOption{
+		option.WithBaseURL(baseURL),
+		// the client retries itself, with failover to other endpoints
+		option.WithMaxRetries(0),
+	}
+	if cfg.APIKey != "" {
+		opts = append(opts, option.WithAPIKey(cfg.APIKey))
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, option.WithRequestTimeout(cfg.Timeout))
+	}
+	for k, v := range cfg.Headers {
+		opts = append(opts, option.WithHeader(k, v))
+	}
+	c := openai.NewClient(opts...)
+	return &openAIProvider{
+		c:         &c,
+		modelName: endpoint.Model,
+		baseURL:   baseURL,
+	}
+}
+
+func (p *openAIProvider) Model() string {
+	return p.modelName
+}
+
+func (p *openAIProvider) SetModel(name string) {
+	p.modelName = name
+}
+
+func (p *openAIProvider) Name() string {
+	return p.modelName + "@" + p.baseURL
+}
+
+func (p *openAIProvider) Chat(
+	ctx context.Context,
+	conversation []openai.ChatCompletionMessageParamUnion,
+	tools ToolSet,
+	onDelta func(string),
+) (openai.ChatCompletionMessage, Usage, error) {
+	params := openai.ChatCompletionNewParams{
+		Messages: conversation,
+		Model:    p.modelName,
+	}
+	if tools != nil {
+		params.Tools = tools.OpenAI()
+	}
+	var message openai.ChatCompletionMessage
+
+	if onDelta == nil {
+		chatCompletion, err := p.c.Chat.Completions.New(ctx, params)
+		if err != nil {
+			return message, Usage{}, err
+		}
+		if len(chatCompletion.Choices) == 0 {
+			return message, usageOf(chatCompletion.Usage), errEmptyResponse
+		}
+		return chatCompletion.Choices[0].Message, usageOf(chatCompletion.Usage), nil
+	}
+
+	// ask for the usage in the last chunk, servers that do not know the
+	// option ignore it
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{
+		IncludeUsage: openai.Bool(true),
+	}
+	stream := p.c.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	acc := openai.ChatCompletionAccumulator{}
+	// the accumulator drops the details of the usage
+	cached := int64(0)
+	for stream.Next() {
+		chunk := stream.Current()
+		if !acc.AddChunk(chunk) {
+			return message, Usage{}, fmt.Errorf("could not accumulate chunk %s", chunk.ID)
+		}
+		cached += chunk.Usage.PromptTokensDetails.CachedTokens
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			onDelta(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return message, Usage{}, err
+	}
+	acc.Usage.PromptTokensDetails.CachedTokens = cached
+	if len(acc.Choices) == 0 {
+		return message, usageOf(acc.Usage), errEmptyResponse
+	}
+	message = acc.Choices[0].Message
+	message.Role = "assistant"
+	return message, usageOf(acc.Usage), nil
+}
+
+func usageOf(u openai.CompletionUsage) Usage {
+	return Usage{
+		PromptTokens:     int(u.PromptTokens),
+		CompletionTokens: int(u.CompletionTokens),
+		CachedTokens:     int(u.PromptTokensDetails.CachedTokens),
+	}
+}
+
+func (p *openAIProvider) complete(ctx context.Context, prompt string) (string, error) {
+	completion, err := p.c.Completions.New(ctx, openai.CompletionNewParams{
+		Prompt: openai.CompletionNewParamsPromptUnion{
+			OfString: openai.String(prompt),
+		},
+
+		Model: openai.CompletionNewParamsModel(p.modelName),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 {
+		return "", errEmptyResponse
+	}
+	return completion.Choices[0].Text, nil
+}
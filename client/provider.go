@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/moritz-tiesler/sous/config"
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go"
+)
+
+// Provider talks to a model backend. Conversations and responses are
+// expressed with the openai types regardless of the backend, so the agent
+// loop does not need to know which one is in use.
+type Provider interface {
+	// Chat sends the conversation to the model. If onDelta is not nil the
+	// response is streamed and onDelta is called with every content
+	// fragment as it arrives.
+	Chat(
+		ctx context.Context,
+		conversation []openai.ChatCompletionMessageParamUnion,
+		tools ToolSet,
+		onDelta func(string),
+	) (openai.ChatCompletionMessage, Usage, error)
+	// Model returns the model requests are sent to.
+	Model() string
+	// SetModel switches the model of the following requests.
+	SetModel(name string)
+	// Name describes the endpoint in log messages.
+	Name() string
+}
+
+// Usage is the number of tokens a request used, as reported by the
+// backend. The counts are zero if the backend did not report them.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	// CachedTokens is the part of the prompt tokens that was served from
+	// the prompt cache of the backend.
+	CachedTokens int
+	// Latency is the time from sending the request to the end of the
+	// response, without the failed attempts before it.
+	Latency time.Duration
+	// Model is the model that answered, which is a fallback if the
+	// client failed over.
+	Model string
+}
+
+// ToolSet provides the tool definitions in the format of every backend.
+type ToolSet interface {
+	OpenAI() []openai.ChatCompletionToolParam
+	Ollama() api.Tools
+}
+
+// NewProvider creates a provider of the kind selected in cfg for the model
+// at endpoint. An endpoint without base URL uses the one of cfg.
+func NewProvider(cfg *config.Config, endpoint config.Endpoint) (Provider, error) {
+	switch cfg.Provider {
+	case "openai":
+		return newOpenAIProvider(cfg, endpoint), nil
+	case "ollama":
+		return newOllamaProvider(cfg, endpoint)
+	}
+	return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	// retryBaseDelay is the delay before the first retry, it doubles with
+	// every further one up to retryMaxDelay.
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+	// maxRetryAfter bounds the delay a server may ask for.
+	maxRetryAfter = 2 * time.Minute
+	// failbackAfter is how long requests go to a fallback endpoint before
+	// the primary one is tried again.
+	failbackAfter = 5 * time.Minute
+)
+
+// chat sends the request to the endpoints in turn. Transient failures are
+// retried with backoff on the same endpoint, once the retries are used up
+// the next endpoint is tried. Other failures, e.g. cancelled requests,
+// conversations that are too long or bad requests, are returned at once.
+func (c *Client) chat(
+	ctx context.Context,
+	conversation []openai.ChatCompletionMessageParamUnion,
+	tools ToolSet,
+	onDelta func(string),
+) (openai.ChatCompletionMessage, Usage, error) {
+	var message openai.ChatCompletionMessage
+	var usage Usage
+	var err error
+	order := c.order()
+	for i, index := range order {
+		p := c.providers[index]
+		if i > 0 {
+			c.logf("failing over to %s", p.Name())
+		}
+		for attempt := 0; ; attempt++ {
+			start := time.Now()
+			message, usage, err = p.Chat(ctx, conversation, tools, onDelta)
+			usage.Latency, usage.Model = time.Since(start), p.Model()
+			err = classify(ctx, err)
+			if err == nil && strings.TrimSpace(message.Content) == "" && len(message.ToolCalls) == 0 {
+				err = errEmptyResponse
+			}
+			if err == nil {
+				c.succeeded(index)
+				return message, usage, nil
+			}
+			// other endpoints would reject a bad request or key
+			// alike and hide the error, so only transient failures
+			// fail over
+			if !KindOf(err).Transient() {
+				return message, usage, err
+			}
+			if attempt >= c.MaxRetries {
+				if i < len(order)-1 {
+					c.logf("%s failed: %s", p.Name(), err.Error())
+				}
+				break
+			}
+			delay := backoff(err, attempt)
+			c.logf("attempt %d of %d at %s failed: %s, retrying in %s",
+				attempt+1, c.MaxRetries+1, p.Name(), err.Error(), delay.Round(100*time.Millisecond))
+			if !sleep(ctx, delay) {
+				return message, usage, &Error{Kind: KindCancelled, Err: context.Cause(ctx)}
+			}
+		}
+	}
+	return message, usage, err
+}
+
+// order returns the endpoints in the order a request tries them, starting
+// with the one that answered last.
+func (c *Client) order() []int {
+	if c.active != 0 && time.Since(c.failedOver) > failbackAfter {
+		c.logf("trying %s again", c.providers[0].Name())
+		c.active = 0
+	}
+	order := []int{}
+	for i := range c.providers {
+		order = append(order, (c.active+i)%len(c.providers))
+	}
+	return order
+}
+
+func (c *Client) succeeded(index int) {
+	if index != c.active {
+		c.active = index
+		c.failedOver = time.Now()
+	}
+}
+
+func (c *Client) logf(format string, args ...any) {
+	if c.Log != nil {
+		c.Log(format, args...)
+	}
+}
+
+// backoff returns the delay before retry attempt+1. It honours the delay
+// the server asked for, otherwise it grows exponentially with jitter, so
+// clients that failed together do not retry together.
+func backoff(err error, attempt int) time.Duration {
+	var e *Error
+	if errors.As(err, &e) && e.RetryAfter > 0 {
+		return min(e.RetryAfter, maxRetryAfter)
+	}
+	d := retryMaxDelay
+	if attempt < 16 {
+		d = min(retryBaseDelay<<attempt, retryMaxDelay)
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// sleep waits for d and returns false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
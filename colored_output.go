@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/fatih/color"
 )
@@ -23,3 +24,22 @@ func PrintAction(format string, a ...interface{}) {
 	msg := fmt.Sprintf(format, a...)
 	color.New(color.FgHiMagenta, color.Italic, color.Bold).Print(msg)
 }
+
+// PrintDiff prints a unified diff with removed lines red and added lines green
+func PrintDiff(diff string) {
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			color.New(color.Bold).Print(line)
+		case strings.HasPrefix(line, "@@"):
+			color.New(color.FgCyan).Print(line)
+		case strings.HasPrefix(line, "+"):
+			color.New(color.FgGreen).Print(line)
+		case strings.HasPrefix(line, "-"):
+			color.New(color.FgRed).Print(line)
+		default:
+			fmt.Print(line)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Command is a REPL command, invoked by typing /name followed by its
+// arguments.
+type Command struct {
+	Name    string
+	Aliases []string
+	// Usage describes the arguments, e.g. "[turn]".
+	Usage       string
+	Description string
+	// Run executes the command with the text following its name.
+	Run func(ctx context.Context, args string) (Result, error)
+}
+
+// Result tells the REPL what to do after a command ran.
+type Result struct {
+	// Prompt is sent to the model as if the user had typed it.
+	Prompt string
+	// Quit ends the session.
+	Quit bool
+}
+
+// Registry holds the available commands.
+type Registry struct {
+	commands []*Command
+	byName   map[string]*Command
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: map[string]*Command{}}
+}
+
+// Register adds a command. It panics if the name or an alias is taken.
+func (r *Registry) Register(c Command) {
+	for _, name := range append([]string{c.Name}, c.Aliases...) {
+		if _, ok := r.byName[name]; ok {
+			panic(fmt.Sprintf("command /%s registered twice", name))
+		}
+	}
+	cmd := &c
+	r.commands = append(r.commands, cmd)
+	for _, name := range append([]string{c.Name}, c.Aliases...) {
+		r.byName[name] = cmd
+	}
+}
+
+// Has reports whether name is a command or an alias.
+func (r *Registry) Has(name string) bool {
+	_, ok := r.byName[name]
+	return ok
+}
+
+// All returns the commands sorted by name.
+func (r *Registry) All() []*Command {
+	all := append([]*Command{}, r.commands...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Parse splits a line of input into the command name and its arguments.
+// It returns false if the line is not a command. A leading slash alone does
+// not make a command, "/usr/bin is missing" is a prompt.
+func Parse(input string) (string, string, bool) {
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, "/") {
+		return "", "", false
+	}
+	name, args, _ := strings.Cut(input[1:], " ")
+	if name == "" || strings.ContainsAny(name, "/\t") {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(args), true
+}
+
+// Lookup returns the command input invokes and its arguments. It returns
+// an error for unknown commands and false if input is not a command.
+func (r *Registry) Lookup(input string) (*Command, string, bool, error) {
+	name, args, ok := Parse(input)
+	if !ok {
+		return nil, "", false, nil
+	}
+	c, ok := r.byName[name]
+	if !ok {
+		return nil, "", true, fmt.Errorf("unknown command /%s, see /help", name)
+	}
+	return c, args, true, nil
+}
+
+// Complete returns the command names starting with prefix, each with its
+// leading slash.
+func (r *Registry) Complete(prefix string) []string {
+	prefix = strings.TrimPrefix(prefix, "/")
+	matches := []string{}
+	for name := range r.byName {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, "/"+name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// Help formats the list of commands.
+func (r *Registry) Help() string {
+	sb := strings.Builder{}
+	for _, c := range r.All() {
+		usage := "/" + c.Name
+		if c.Usage != "" {
+			usage += " " + c.Usage
+		}
+		fmt.Fprintf(&sb, "  %-24s %s", usage, c.Description)
+		if len(c.Aliases) > 0 {
+			fmt.Fprintf(&sb, " (also /%s)", strings.Join(c.Aliases, ", /"))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
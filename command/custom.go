@@ -0,0 +1,161 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dir returns the directory the custom commands of the project in root are
+// loaded from.
+func Dir(root string) string {
+	return filepath.Join(root, ".sous", "commands")
+}
+
+// LoadCustom registers a command for every markdown file in the dirs. The
+// file name without .md is the name of the command, its content a prompt
+// template. Later dirs override earlier ones, commands that are already
+// registered, e.g. built in ones, are skipped and reported in the error.
+//
+// The template may start with a front matter block holding a description:
+//
+//	---
+//	description: Review the changes on this branch
+//	---
+//	Review the diff of $1 against main, focus on $ARGUMENTS.
+func (r *Registry) LoadCustom(dirs ...string) error {
+	templates := map[string]template{}
+	names := []string{}
+	errs := []error{}
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.md"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, file := range files {
+			t, err := readTemplate(file)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			name := strings.TrimSuffix(filepath.Base(file), ".md")
+			if _, ok := templates[name]; !ok {
+				names = append(names, name)
+			}
+			templates[name] = t
+		}
+	}
+	for _, name := range names {
+		t := templates[name]
+		if r.Has(name) || strings.ContainsAny(name, " \t") {
+			errs = append(errs, fmt.Errorf("%s: /%s is not available as a custom command", t.file, name))
+			continue
+		}
+		description := t.description
+		if description == "" {
+			description = "custom command from " + t.file
+		}
+		r.Register(Command{
+			Name:        name,
+			Usage:       "[arguments]",
+			Description: description,
+			Run: func(ctx context.Context, args string) (Result, error) {
+				return Result{Prompt: Expand(t.body, args)}, nil
+			},
+		})
+	}
+	return errors.Join(errs...)
+}
+
+type template struct {
+	file        string
+	description string
+	body        string
+}
+
+func readTemplate(file string) (template, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return template{}, err
+	}
+	t := template{file: file, body: string(data)}
+	if rest, ok := strings.CutPrefix(t.body, "---\n"); ok {
+		front, body, found := strings.Cut(rest, "\n---\n")
+		if found {
+			t.body = body
+			for _, line := range strings.Split(front, "\n") {
+				key, value, _ := strings.Cut(line, ":")
+				if strings.TrimSpace(key) == "description" {
+					t.description = strings.TrimSpace(value)
+				}
+			}
+		}
+	}
+	t.body = strings.TrimSpace(t.body)
+	if t.body == "" {
+		return t, fmt.Errorf("%s: the template is empty", file)
+	}
+	return t, nil
+}
+
+var placeholder = regexp.MustCompile(`\$(ARGUMENTS|[1-9])`)
+
+// Expand substitutes the arguments into a template. $ARGUMENTS is replaced
+// by all arguments, $1 to $9 by single ones, where double quotes group
+// words into one argument. Without placeholders the arguments are appended
+// to the template.
+func Expand(template string, args string) string {
+	if !placeholder.MatchString(template) {
+		if args == "" {
+			return template
+		}
+		return template + "\n\n" + args
+	}
+	fields := splitArgs(args)
+	return placeholder.ReplaceAllStringFunc(template, func(p string) string {
+		if p == "$ARGUMENTS" {
+			return args
+		}
+		n, _ := strconv.Atoi(p[1:])
+		if n > len(fields) {
+			return ""
+		}
+		return fields[n-1]
+	})
+}
+
+func splitArgs(args string) []string {
+	fields := []string{}
+	current := strings.Builder{}
+	inQuotes, started := false, false
+	for _, r := range args {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			started = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if started {
+				fields = append(fields, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
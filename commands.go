@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moritz-tiesler/sous/command"
+	"github.com/moritz-tiesler/sous/config"
+	"github.com/moritz-tiesler/sous/history"
+	"github.com/moritz-tiesler/sous/session"
+)
+
+// registerCommands adds the built in REPL commands.
+func (a *Agent) registerCommands() {
+	commands := []command.Command{
+		{
+			Name:        "help",
+			Description: "list the commands",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				PrintAction("Commands:\n")
+				fmt.Print(a.commands.Help())
+				return command.Result{}, nil
+			},
+		},
+		{
+			Name:        "clear",
+			Description: "start a new conversation",
+			Run:         a.clearConversation,
+		},
+		{
+			Name:        "model",
+			Usage:       "[name]",
+			Description: "show the model or switch to another one",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				if args == "" {
+					PrintAction("Model: %s\n", a.client.Model())
+					return command.Result{}, nil
+				}
+				a.client.SetModel(args)
+				if a.session != nil {
+					a.session.Model = args
+				}
+				PrintAction("Switched to model %s\n", args)
+				return command.Result{}, nil
+			},
+		},
+		{
+			Name:        "tools",
+			Description: "list the tools and their permission mode",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				for _, t := range a.tools.All() {
+					description, _, _ := strings.Cut(t.Description, "\n")
+					if len(description) > 80 {
+						description = description[:77] + "..."
+					}
+					PrintAction("%-14s %-5s ", t.Name, a.permissions.ModeOf(t.Name))
+					fmt.Println(description)
+				}
+				return command.Result{}, nil
+			},
+		},
+		{
+			Name:        "cost",
+			Aliases:     []string{"usage"},
+			Description: "show the tokens, time and cost of the last turn and the session",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				a.showUsage()
+				return command.Result{}, nil
+			},
+		},
+		{
+			Name:        "save",
+			Usage:       "[file]",
+			Description: "write the conversation to a markdown file",
+			Run:         a.saveTranscript,
+		},
+		{
+			Name:        "quit",
+			Aliases:     []string{"exit"},
+			Description: "end the session",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				return command.Result{Quit: true}, nil
+			},
+		},
+	}
+	commands = append(commands, a.historyCommands()...)
+	commands = append(commands, a.checkpointCommands()...)
+	for _, c := range commands {
+		a.commands.Register(c)
+	}
+}
+
+// loadCustomCommands registers the prompt templates of the user and of the
+// project, the latter win on conflicts.
+func (a *Agent) loadCustomCommands() {
+	err := a.commands.LoadCustom(
+		filepath.Join(filepath.Dir(config.DefaultPath()), "commands"),
+		command.Dir(a.root),
+	)
+	if err != nil {
+		PrintAction("loading the custom commands failed: %s\n", err.Error())
+	}
+}
+
+// runCommand executes input if it is a command. It returns the prompt to
+// send to the model instead of input, and whether input was a command.
+func (a *Agent) runCommand(ctx context.Context, input string) (command.Result, bool) {
+	c, args, ok, err := a.commands.Lookup(input)
+	if !ok {
+		return command.Result{}, false
+	}
+	if err != nil {
+		PrintAction("%s\n", err.Error())
+		return command.Result{}, true
+	}
+	result, err := c.Run(ctx, args)
+	if err != nil {
+		PrintAction("/%s: %s\n", c.Name, err.Error())
+	}
+	return result, true
+}
+
+// clearConversation drops the conversation and continues in a new session.
+// The files stay as they are, but the old turns can no longer be rewound.
+func (a *Agent) clearConversation(ctx context.Context, args string) (command.Result, error) {
+	a.conversation = withSystemPrompt(nil, a.systemPrompt)
+	a.history.Reset()
+	a.checkpoints.Compacted()
+	if a.session != nil {
+		a.session.Close()
+		a.session = session.New(session.Dir(a.root), a.client.Model())
+		PrintAction("Started a new conversation in session %s\n", a.session.ID)
+		return command.Result{}, nil
+	}
+	PrintAction("Started a new conversation\n")
+	return command.Result{}, nil
+}
+
+// saveTranscript writes the conversation as text, by default next to the
+// session files.
+func (a *Agent) saveTranscript(ctx context.Context, args string) (command.Result, error) {
+	file := args
+	if file == "" {
+		id := "conversation"
+		if a.session != nil {
+			id = a.session.ID
+		}
+		file = filepath.Join(session.Dir(a.root), id+".md")
+	}
+	messages := a.conversation
+	if len(messages) > 0 && messages[0].OfSystem != nil {
+		messages = messages[1:]
+	}
+	text := "# Sous conversation\n\n" + history.Transcript(messages, 0)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return command.Result{}, err
+	}
+	if err := os.WriteFile(file, []byte(text), 0o644); err != nil {
+		return command.Result{}, err
+	}
+	PrintAction("Saved %d messages to %s\n", len(messages), file)
+	return command.Result{}, nil
+}
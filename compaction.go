@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/moritz-tiesler/sous/command"
+	"github.com/moritz-tiesler/sous/history"
+	"github.com/openai/openai-go"
+)
+
+// compact replaces the older part of the conversation with a summary. The
+// original task, pinned messages and the latest turns are kept verbatim.
+// focus are optional instructions for the summary. On failure the
+// conversation is returned unchanged.
+func (a *Agent) compact(
+	ctx context.Context,
+	conversation []openai.ChatCompletionMessageParamUnion,
+	focus string,
+) []openai.ChatCompletionMessageParamUnion {
+	plan, ok := a.history.Plan(conversation)
+	if !ok {
+		PrintAction("There is nothing old enough to summarize\n")
+		return conversation
+	}
+	PrintAction("SUMMARIZING %d of %d messages (%d tokens of %d)...\n",
+		len(plan.Old), len(conversation), a.history.Tokens(conversation), a.history.Window)
+	old := append([]openai.ChatCompletionMessageParamUnion{plan.Task}, plan.Old...)
+	summary, err := a.summarizeConvo(ctx, old, focus)
+	if err == nil && strings.TrimSpace(summary.Content) == "" {
+		err = fmt.Errorf("the model returned an empty summary")
+	}
+	if err != nil {
+		PrintAction("summarizing failed, keeping the conversation: %s\n", err.Error())
+		return conversation
+	}
+	conversation = a.history.Apply(plan, summary.Content)
+	a.checkpoints.Compacted()
+	if a.session != nil {
+		a.session.Reset(0)
+	}
+	a.saveSession(conversation)
+	PrintAction("Compacted the conversation to %d messages, about %d tokens\n",
+		len(conversation), a.history.Tokens(conversation))
+	return conversation
+}
+
+// summarizeConvo asks the model for a summary of conversation in a
+// separate request without tools.
+func (a *Agent) summarizeConvo(
+	ctx context.Context,
+	conversation []openai.ChatCompletionMessageParamUnion,
+	focus string,
+) (openai.ChatCompletionMessage, error) {
+	message, err := a.client.RunInference(ctx, history.SummaryRequest(conversation, focus), nil)
+	a.addUsage(a.client.LastUsage(), err)
+	return message, err
+}
+
+// historyCommands are the REPL commands for the context window.
+func (a *Agent) historyCommands() []command.Command {
+	return []command.Command{
+		{
+			Name:        "compact",
+			Usage:       "[focus]",
+			Description: "summarize the older part of the conversation now",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				a.conversation = a.compact(ctx, a.conversation, args)
+				return command.Result{}, nil
+			},
+		},
+		{
+			Name:        "context",
+			Description: "show how much of the context window is used",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				tokens := a.history.Tokens(a.conversation)
+				PrintAction("%d messages, about %d of %d tokens (%.0f%%), compacting at %d\n",
+					len(a.conversation), tokens, a.history.Window,
+					100*float64(tokens)/float64(max(1, a.history.Window)), a.history.Limit())
+				if pinned := a.history.Pinned(); len(pinned) > 0 {
+					PrintAction("pinned messages: %v\n", pinned)
+				}
+				return command.Result{}, nil
+			},
+		},
+		{
+			Name:        "pin",
+			Description: "keep the last message of the user verbatim when compacting",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				for i := len(a.conversation) - 1; i >= 0; i-- {
+					if a.conversation[i].OfUser != nil && !history.IsSummary(a.conversation[i]) {
+						a.history.Pin(i)
+						PrintAction("Pinned message %d\n", i)
+						return command.Result{}, nil
+					}
+				}
+				return command.Result{}, fmt.Errorf("there is no message to pin yet")
+			},
+		},
+		{
+			Name:        "unpin",
+			Description: "release all pinned messages",
+			Run: func(ctx context.Context, args string) (command.Result, error) {
+				a.history.Unpin()
+				PrintAction("Released all pinned messages\n")
+				return command.Result{}, nil
+			},
+		},
+	}
+}
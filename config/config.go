@@ -0,0 +1,680 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the effective configuration of sous. Values are resolved in
+// layers: defaults, config file, environment variables, command-line flags.
+// Later layers win.
+type Config struct {
+	Provider string
+	BaseURL  string
+	Model    string
+	APIKey   string
+	Timeout  time.Duration
+	Headers  map[string]string
+	Ignore   []string
+	// MaxRetries is the number of times a request that failed for a
+	// transient reason is repeated on the same endpoint.
+	MaxRetries int
+	// Fallbacks are tried in order when the model at BaseURL fails.
+	Fallbacks []Endpoint
+	// Prices maps model names to their prices, models without one are
+	// shown without cost.
+	Prices map[string]Price
+
+	ShellTimeout time.Duration
+	// Sandbox is the isolation mode of shell commands, one of off, auto,
+	// bwrap, namespace.
+	Sandbox        string
+	SandboxNetwork bool
+	// ContextWindow is the context size of the model in tokens, the
+	// conversation is compacted when it uses CompactThreshold of it.
+	ContextWindow    int
+	CompactThreshold float64
+
+	// The limits of the agent loop, zero disables a limit. MaxToolRounds
+	// and MaxTurnTime apply to a single user turn, MaxSessionTokens to the
+	// whole session. MaxRepeatedCalls stops a model that makes the same
+	// tool call over and over.
+	MaxToolRounds    int
+	MaxTurnTime      time.Duration
+	MaxSessionTokens int
+	MaxRepeatedCalls int
+
+	sources map[string]string
+}
+
+// field describes a single configuration value and the places it can be
+// set from.
+type field struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	// boolean fields can be given as a bare flag, e.g. -sandbox-network
+	boolean bool
+	set     func(c *Config, v string) error
+	get     func(c *Config) string
+}
+
+var fields = []field{
+	{
+		key:   "provider",
+		env:   "SOUS_PROVIDER",
+		flag:  "provider",
+		usage: "model backend, one of openai, ollama",
+		set: func(c *Config, v string) error {
+			switch v {
+			case "openai", "ollama":
+				c.Provider = v
+				return nil
+			}
+			return fmt.Errorf("unknown provider %q", v)
+		},
+		get: func(c *Config) string { return c.Provider },
+	},
+	{
+		key:   "base_url",
+		env:   "SOUS_BASE_URL",
+		flag:  "base-url",
+		usage: "base URL of the OpenAI compatible endpoint",
+		set:   func(c *Config, v string) error { c.BaseURL = v; return nil },
+		get:   func(c *Config) string { return c.BaseURL },
+	},
+	{
+		key:   "model",
+		env:   "SOUS_MODEL",
+		flag:  "model",
+		usage: "name of the model to chat with",
+		set:   func(c *Config, v string) error { c.Model = v; return nil },
+		get:   func(c *Config) string { return c.Model },
+	},
+	{
+		key:   "api_key",
+		env:   "SOUS_API_KEY",
+		flag:  "api-key",
+		usage: "API key sent as bearer token",
+		set:   func(c *Config, v string) error { c.APIKey = v; return nil },
+		get:   func(c *Config) string { return redact(c.APIKey) },
+	},
+	{
+		key:   "timeout",
+		env:   "SOUS_TIMEOUT",
+		flag:  "timeout",
+		usage: "timeout of a single model request, e.g. 5m",
+		set: func(c *Config, v string) error {
+			d, err := parseDuration(v)
+			if err != nil {
+				return err
+			}
+			c.Timeout = d
+			return nil
+		},
+		get: func(c *Config) string { return c.Timeout.String() },
+	},
+	{
+		key:   "max_retries",
+		env:   "SOUS_MAX_RETRIES",
+		flag:  "max-retries",
+		usage: "retries of a model request that failed for a transient reason",
+		set: func(c *Config, v string) error {
+			n, err := parseLimit(v)
+			if err != nil {
+				return err
+			}
+			c.MaxRetries = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxRetries) },
+	},
+	{
+		key:   "fallbacks",
+		env:   "SOUS_FALLBACKS",
+		flag:  "fallbacks",
+		usage: "models to fail over to, comma separated model@base_url, the base URL defaults to base_url",
+		set: func(c *Config, v string) error {
+			list, err := parseList(v)
+			if err != nil {
+				return err
+			}
+			c.Fallbacks = nil
+			for _, item := range list {
+				e, err := ParseEndpoint(item)
+				if err != nil {
+					return err
+				}
+				c.Fallbacks = append(c.Fallbacks, e)
+			}
+			return nil
+		},
+		get: func(c *Config) string {
+			items := make([]string, len(c.Fallbacks))
+			for i, e := range c.Fallbacks {
+				items[i] = e.String()
+			}
+			return strings.Join(items, ",")
+		},
+	},
+	{
+		key:   "prices",
+		env:   "SOUS_PRICES",
+		flag:  "price",
+		usage: "price of a model in USD per million tokens as model=input/output[/cached], may be repeated",
+		set:   setPrices,
+		get: func(c *Config) string {
+			models := make([]string, 0, len(c.Prices))
+			for m := range c.Prices {
+				models = append(models, m)
+			}
+			sort.Strings(models)
+			items := make([]string, len(models))
+			for i, m := range models {
+				items[i] = m + "=" + c.Prices[m].String()
+			}
+			return strings.Join(items, ",")
+		},
+	},
+	{
+		key:   "headers",
+		env:   "SOUS_HEADERS",
+		flag:  "header",
+		usage: "default request header as key=value, may be repeated",
+		set:   setHeaders,
+		get: func(c *Config) string {
+			keys := make([]string, 0, len(c.Headers))
+			for k := range c.Headers {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			pairs := make([]string, len(keys))
+			for i, k := range keys {
+				pairs[i] = k + "=" + c.Headers[k]
+			}
+			return strings.Join(pairs, ",")
+		},
+	},
+	{
+		key:   "ignore",
+		env:   "SOUS_IGNORE",
+		flag:  "ignore",
+		usage: "comma separated directories the file tools skip",
+		set: func(c *Config, v string) error {
+			list, err := parseList(v)
+			c.Ignore = list
+			return err
+		},
+		get: func(c *Config) string { return strings.Join(c.Ignore, ",") },
+	},
+	{
+		key:   "shell_timeout",
+		env:   "SOUS_SHELL_TIMEOUT",
+		flag:  "shell-timeout",
+		usage: "default timeout of shell tool calls, e.g. 2m",
+		set: func(c *Config, v string) error {
+			d, err := parseDuration(v)
+			if err != nil {
+				return err
+			}
+			c.ShellTimeout = d
+			return nil
+		},
+		get: func(c *Config) string { return c.ShellTimeout.String() },
+	},
+	{
+		key:   "sandbox",
+		env:   "SOUS_SANDBOX",
+		flag:  "sandbox",
+		usage: "run shell commands in a sandbox, one of off, auto, bwrap, namespace",
+		set: func(c *Config, v string) error {
+			switch v {
+			case "off", "auto", "bwrap", "namespace":
+				c.Sandbox = v
+				return nil
+			}
+			return fmt.Errorf("unknown sandbox mode %q", v)
+		},
+		get: func(c *Config) string { return c.Sandbox },
+	},
+	{
+		key:     "sandbox_network",
+		env:     "SOUS_SANDBOX_NETWORK",
+		flag:    "sandbox-network",
+		usage:   "allow network access inside the sandbox",
+		boolean: true,
+		set: func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			c.SandboxNetwork = b
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatBool(c.SandboxNetwork) },
+	},
+	{
+		key:   "context_window",
+		env:   "SOUS_CONTEXT_WINDOW",
+		flag:  "context-window",
+		usage: "context window of the model in tokens",
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return fmt.Errorf("context window must be positive, got %d", n)
+			}
+			c.ContextWindow = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.ContextWindow) },
+	},
+	{
+		key:   "compact_threshold",
+		env:   "SOUS_COMPACT_THRESHOLD",
+		flag:  "compact-threshold",
+		usage: "fraction of the context window at which the conversation is summarized, e.g. 0.8",
+		set: func(c *Config, v string) error {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			if f <= 0 || f > 1 {
+				return fmt.Errorf("compact threshold must be in (0, 1], got %v", f)
+			}
+			c.CompactThreshold = f
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatFloat(c.CompactThreshold, 'g', -1, 64) },
+	},
+	{
+		key:   "max_tool_rounds",
+		env:   "SOUS_MAX_TOOL_ROUNDS",
+		flag:  "max-tool-rounds",
+		usage: "rounds of tool calls the model may make for one prompt, 0 for no limit",
+		set: func(c *Config, v string) error {
+			n, err := parseLimit(v)
+			if err != nil {
+				return err
+			}
+			c.MaxToolRounds = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxToolRounds) },
+	},
+	{
+		key:   "max_turn_time",
+		env:   "SOUS_MAX_TURN_TIME",
+		flag:  "max-turn-time",
+		usage: "time the agent may work on one prompt, e.g. 30m, 0 for no limit",
+		set: func(c *Config, v string) error {
+			d, err := parseDuration(v)
+			if err != nil {
+				return err
+			}
+			if d < 0 {
+				return fmt.Errorf("max turn time must not be negative, got %s", d)
+			}
+			c.MaxTurnTime = d
+			return nil
+		},
+		get: func(c *Config) string { return c.MaxTurnTime.String() },
+	},
+	{
+		key:   "max_session_tokens",
+		env:   "SOUS_MAX_SESSION_TOKENS",
+		flag:  "max-session-tokens",
+		usage: "prompt and completion tokens the session may use, 0 for no limit",
+		set: func(c *Config, v string) error {
+			n, err := parseLimit(v)
+			if err != nil {
+				return err
+			}
+			c.MaxSessionTokens = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxSessionTokens) },
+	},
+	{
+		key:   "max_repeated_calls",
+		env:   "SOUS_MAX_REPEATED_CALLS",
+		flag:  "max-repeated-calls",
+		usage: "identical tool calls with identical results in a row after which the agent stops, 0 for no limit",
+		set: func(c *Config, v string) error {
+			n, err := parseLimit(v)
+			if err != nil {
+				return err
+			}
+			c.MaxRepeatedCalls = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.MaxRepeatedCalls) },
+	},
+}
+
+func defaults() *Config {
+	return &Config{
+		Provider: "openai",
+		BaseURL:  "http://localhost:1234/v1/",
+		Model:    "Qwen3-14B-128K-GGUF_Qwen3-14B-128K-UD-Q6_K_XL",
+		Timeout:  10 * time.Minute,
+		Headers:  map[string]string{},
+		Prices:   map[string]Price{},
+		Ignore:   []string{"vendor", "node_modules", ".git"},
+
+		MaxRetries: 3,
+
+		ShellTimeout: 2 * time.Minute,
+		Sandbox:      "off",
+
+		ContextWindow:    128 * 1024,
+		CompactThreshold: 0.8,
+
+		MaxToolRounds:    50,
+		MaxTurnTime:      30 * time.Minute,
+		MaxRepeatedCalls: 3,
+
+		sources: map[string]string{},
+	}
+}
+
+// Endpoint is a model served at a base URL. An empty BaseURL stands for
+// the base URL of the configuration.
+type Endpoint struct {
+	Model   string
+	BaseURL string
+}
+
+// ParseEndpoint parses model@base_url, or just a model name.
+func ParseEndpoint(s string) (Endpoint, error) {
+	model, baseURL, _ := strings.Cut(strings.TrimSpace(s), "@")
+	if model == "" {
+		return Endpoint{}, fmt.Errorf("endpoint %q has no model", s)
+	}
+	return Endpoint{Model: model, BaseURL: baseURL}, nil
+}
+
+func (e Endpoint) String() string {
+	if e.BaseURL == "" {
+		return e.Model
+	}
+	return e.Model + "@" + e.BaseURL
+}
+
+// Price is what a hosted model charges, in USD per million tokens. Cached
+// is the price of prompt tokens served from the prompt cache, Input is
+// charged for them if it is zero.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+	Cached float64 `json:"cached,omitempty"`
+}
+
+// ParsePrice parses input/output or input/output/cached.
+func ParsePrice(s string) (Price, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Price{}, fmt.Errorf("price %q is not of the form input/output[/cached]", s)
+	}
+	values := make([]float64, 3)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return Price{}, fmt.Errorf("price %q: %w", s, err)
+		}
+		if v < 0 {
+			return Price{}, fmt.Errorf("price %q must not be negative", s)
+		}
+		values[i] = v
+	}
+	return Price{Input: values[0], Output: values[1], Cached: values[2]}, nil
+}
+
+func (p Price) String() string {
+	s := strconv.FormatFloat(p.Input, 'f', -1, 64) + "/" + strconv.FormatFloat(p.Output, 'f', -1, 64)
+	if p.Cached != 0 {
+		s += "/" + strconv.FormatFloat(p.Cached, 'f', -1, 64)
+	}
+	return s
+}
+
+// parseDuration parses a duration like 5m. A bare number, e.g. a JSON
+// number in the config file, is taken as seconds.
+func parseDuration(v string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(v)
+}
+
+// parseLimit parses a limit, where 0 means no limit.
+func parseLimit(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("limit must not be negative, got %d", n)
+	}
+	return n, nil
+}
+
+// setHeaders merges headers given either as a JSON object or as a comma
+// separated list of key=value pairs.
+func setHeaders(c *Config, v string) error {
+	if strings.HasPrefix(strings.TrimSpace(v), "{") {
+		var h map[string]string
+		if err := json.Unmarshal([]byte(v), &h); err != nil {
+			return err
+		}
+		for k, val := range h {
+			c.Headers[k] = val
+		}
+		return nil
+	}
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("header %q is not of the form key=value", pair)
+		}
+		c.Headers[strings.TrimSpace(k)] = strings.TrimSpace(val)
+	}
+	return nil
+}
+
+// setPrices merges prices given either as a JSON object of models to
+// {"input", "output", "cached"} objects or as a comma separated list of
+// model=input/output[/cached] pairs.
+func setPrices(c *Config, v string) error {
+	if strings.HasPrefix(strings.TrimSpace(v), "{") {
+		var prices map[string]Price
+		if err := json.Unmarshal([]byte(v), &prices); err != nil {
+			return err
+		}
+		for model, p := range prices {
+			if p.Input < 0 || p.Output < 0 || p.Cached < 0 {
+				return fmt.Errorf("price of %s must not be negative", model)
+			}
+			c.Prices[model] = p
+		}
+		return nil
+	}
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		model, price, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("price %q is not of the form model=input/output[/cached]", pair)
+		}
+		p, err := ParsePrice(price)
+		if err != nil {
+			return err
+		}
+		c.Prices[strings.TrimSpace(model)] = p
+	}
+	return nil
+}
+
+// parseList parses a JSON array of strings or a comma separated list.
+func parseList(v string) ([]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(v), "[") {
+		var list []string
+		err := json.Unmarshal([]byte(v), &list)
+		return list, err
+	}
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+func redact(s string) string {
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", len(s)-8) + s[len(s)-4:]
+}
+
+type flagValue struct {
+	field *field
+	value string
+}
+
+// Flags holds the configuration values given on the command line.
+type Flags struct {
+	path   string
+	values []flagValue
+}
+
+// BindFlags registers a flag for every configuration value on fs.
+func BindFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.path, "config", "", "path of the config file (default "+DefaultPath()+")")
+	for i := range fields {
+		fd := &fields[i]
+		add := func(v string) error {
+			f.values = append(f.values, flagValue{field: fd, value: v})
+			return nil
+		}
+		if fd.boolean {
+			fs.BoolFunc(fd.flag, fd.usage, add)
+		} else {
+			fs.Func(fd.flag, fd.usage, add)
+		}
+	}
+	return f
+}
+
+// DefaultPath returns the location of the config file in the XDG config dir.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "sous", "config.json")
+}
+
+// Load resolves the effective configuration. flags may be nil.
+func Load(flags *Flags) (*Config, error) {
+	c := defaults()
+	for _, fd := range fields {
+		c.sources[fd.key] = "default"
+	}
+
+	path := DefaultPath()
+	explicit := false
+	if p := os.Getenv("SOUS_CONFIG"); p != "" {
+		path, explicit = p, true
+	}
+	if flags != nil && flags.path != "" {
+		path, explicit = flags.path, true
+	}
+	if err := c.loadFile(path); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	for _, fd := range fields {
+		v, ok := os.LookupEnv(fd.env)
+		if !ok {
+			continue
+		}
+		if err := fd.set(c, v); err != nil {
+			return nil, fmt.Errorf("env %s: %w", fd.env, err)
+		}
+		c.sources[fd.key] = "env " + fd.env
+	}
+
+	if flags != nil {
+		for _, fv := range flags.values {
+			if err := fv.field.set(c, fv.value); err != nil {
+				return nil, fmt.Errorf("flag -%s: %w", fv.field.flag, err)
+			}
+			c.sources[fv.field.key] = "flag -" + fv.field.flag
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	for _, fd := range fields {
+		r, ok := raw[fd.key]
+		if !ok {
+			continue
+		}
+		v := string(r)
+		var s string
+		if json.Unmarshal(r, &s) == nil {
+			v = s
+		}
+		if err := fd.set(c, v); err != nil {
+			return fmt.Errorf("config %s: %s: %w", path, fd.key, err)
+		}
+		c.sources[fd.key] = "file " + path
+	}
+	return nil
+}
+
+// Source reports which layer the value of key was taken from.
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// Show writes every resolved value together with the layer it came from.
+func (c *Config) Show(w io.Writer) {
+	width := 0
+	for _, fd := range fields {
+		width = max(width, len(fd.key))
+	}
+	for _, fd := range fields {
+		fmt.Fprintf(w, "%-*s %-50s (%s)\n", width, fd.key, fd.get(c), c.sources[fd.key])
+	}
+}
@@ -7,6 +7,8 @@ require (
 	github.com/fatih/color v1.18.0
 	github.com/ollama/ollama v0.9.5
 	github.com/openai/openai-go v1.8.2
+	golang.org/x/sys v0.32.0
+	golang.org/x/term v0.31.0
 )
 
 require (
@@ -38,7 +40,5 @@ require (
 	github.com/yuin/goldmark-emoji v1.0.5 // indirect
 	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/net v0.38.0 // indirect
-	golang.org/x/sys v0.32.0 // indirect
-	golang.org/x/term v0.31.0 // indirect
 	golang.org/x/text v0.24.0 // indirect
 )
@@ -0,0 +1,147 @@
+package guard
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Limits bound the work the agent does without the user. A zero value
+// disables a limit.
+type Limits struct {
+	// ToolRounds is the number of tool call rounds for one user turn.
+	ToolRounds int
+	// TurnTime is the wall time of one user turn.
+	TurnTime time.Duration
+	// SessionTokens is the number of prompt and completion tokens of the
+	// session.
+	SessionTokens int
+	// RepeatedCalls is the number of identical tool calls in a row that
+	// also had identical results.
+	RepeatedCalls int
+}
+
+// Names of the limits, as reported by LimitError.
+const (
+	LimitToolRounds    = "max_tool_rounds"
+	LimitTurnTime      = "max_turn_time"
+	LimitSessionTokens = "max_session_tokens"
+	LimitRepeatedCalls = "max_repeated_calls"
+)
+
+// LimitError tells which limit stopped the agent.
+type LimitError struct {
+	Limit  string
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Reason, e.Limit)
+}
+
+// Guard enforces the limits on the agent loop. StartTurn is called for
+// every prompt of the user, BeforeRequest before every model request,
+// CheckCalls before the tool calls of a response run and CheckResult with
+// the result of every call.
+type Guard struct {
+	// Polling are tools that are never counted as repeated, calling them
+	// over and over is how the model waits for output.
+	Polling map[string]bool
+
+	limits Limits
+
+	tokenLimit int
+	turnStart  time.Time
+	rounds     int
+	lastCall   string
+	lastResult string
+	repeats    int
+}
+
+func New(limits Limits) *Guard {
+	return &Guard{
+		limits:     limits,
+		tokenLimit: limits.SessionTokens,
+	}
+}
+
+// StartTurn resets the limits of a user turn.
+func (g *Guard) StartTurn() {
+	g.turnStart = time.Now()
+	g.rounds = 0
+	g.lastCall, g.lastResult, g.repeats = "", "", 0
+}
+
+// BeforeRequest checks the time of the turn and the tokens used by the
+// session so far.
+func (g *Guard) BeforeRequest(sessionTokens int) error {
+	if g.limits.TurnTime > 0 && time.Since(g.turnStart) >= g.limits.TurnTime {
+		return &LimitError{
+			Limit:  LimitTurnTime,
+			Reason: fmt.Sprintf("the turn took longer than %s", g.limits.TurnTime),
+		}
+	}
+	if g.tokenLimit > 0 && sessionTokens >= g.tokenLimit {
+		return &LimitError{
+			Limit:  LimitSessionTokens,
+			Reason: fmt.Sprintf("the session used %d tokens, the limit is %d", sessionTokens, g.tokenLimit),
+		}
+	}
+	return nil
+}
+
+// CheckCalls counts a round of tool calls and checks whether they may run.
+func (g *Guard) CheckCalls(calls []openai.ChatCompletionMessageToolCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	g.rounds++
+	if g.limits.ToolRounds > 0 && g.rounds > g.limits.ToolRounds {
+		return &LimitError{
+			Limit:  LimitToolRounds,
+			Reason: fmt.Sprintf("the model made %d rounds of tool calls for this prompt", g.limits.ToolRounds),
+		}
+	}
+	return nil
+}
+
+// CheckResult checks whether the model is stuck calling a tool with the
+// same arguments and getting the same result. A call with a different
+// result, e.g. a test run after a fix, is progress and not counted.
+func (g *Guard) CheckResult(call openai.ChatCompletionMessageToolCall, result string) error {
+	if g.Polling[call.Function.Name] {
+		return nil
+	}
+	key := call.Function.Name + "\x00" + call.Function.Arguments
+	if key == g.lastCall && result == g.lastResult {
+		g.repeats++
+	} else {
+		g.lastCall, g.lastResult, g.repeats = key, result, 1
+	}
+	if g.limits.RepeatedCalls > 0 && g.repeats >= g.limits.RepeatedCalls {
+		return &LimitError{
+			Limit: LimitRepeatedCalls,
+			Reason: fmt.Sprintf("the model called %s with the same arguments and got the same result %d times in a row",
+				call.Function.Name, g.repeats),
+		}
+	}
+	return nil
+}
+
+// Continue lets the agent go on after err stopped it, by granting another
+// full allowance of the limit that was hit.
+func (g *Guard) Continue(err *LimitError, sessionTokens int) {
+	switch err.Limit {
+	case LimitToolRounds:
+		// the round that hit the limit runs as the first of the new
+		// allowance
+		g.rounds = 1
+	case LimitTurnTime:
+		g.turnStart = time.Now()
+	case LimitSessionTokens:
+		g.tokenLimit = sessionTokens + g.limits.SessionTokens
+	case LimitRepeatedCalls:
+		g.lastCall, g.lastResult, g.repeats = "", "", 0
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/moritz-tiesler/sous/guard"
+)
+
+// offerContinue reports the limit that stopped the agent and asks the user
+// whether it should go on. It returns true if the user wants to continue.
+func (a *Agent) offerContinue(err error) bool {
+	var limit *guard.LimitError
+	if !errors.As(err, &limit) {
+		return false
+	}
+	PrintAction("Stopped: %s\n", limit.Error())
+	answer, ok := a.getUserInput("Continue? [y/N]: ")
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if ok && (answer == "y" || answer == "yes") {
+		a.guard.Continue(limit, a.sessionTokens())
+		return true
+	}
+	PrintAction("Stopped, the limit can be changed with %s in the config\n", limit.Limit)
+	return false
+}
+
+// checkResults passes the results of the tool calls to the guard, which
+// stops a model that repeats itself.
+func (a *Agent) checkResults(runs []toolRun) error {
+	for _, run := range runs {
+		if err := a.guard.CheckResult(run.Call, run.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sessionTokens is the number of tokens used by the session so far.
+func (a *Agent) sessionTokens() int {
+	return a.usage.Session().Tokens()
+}
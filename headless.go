@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/moritz-tiesler/sous/client"
+	"github.com/moritz-tiesler/sous/guard"
+	"github.com/openai/openai-go"
+)
+
+// Output formats of a headless run.
+const (
+	outputText       = "text"
+	outputJSON       = "json"
+	outputStreamJSON = "stream-json"
+)
+
+// Exit codes of a headless run. exitLimit means that -max-turns or one of
+// the configured limits stopped the agent before it finished.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitLimit       = 2
+	exitInterrupted = 130
+)
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON, outputStreamJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q, want text, json or stream-json", format)
+}
+
+// headlessPrompt returns the prompt of a run without the REPL and whether
+// sous runs without it. That is the case if a prompt was given with -p or
+// the input is piped in. Piped input is appended to the prompt.
+func headlessPrompt(prompt string) (string, bool, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return "", false, err
+	}
+	piped := info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+	if prompt == "" && !piped {
+		return "", false, nil
+	}
+	if piped {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", false, fmt.Errorf("reading the input failed: %w", err)
+		}
+		if input := strings.TrimSpace(string(data)); input != "" {
+			if prompt != "" {
+				prompt += "\n\n"
+			}
+			prompt += input
+		}
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return "", false, errors.New("the prompt is empty")
+	}
+	return prompt, true, nil
+}
+
+type headlessOptions struct {
+	// MaxTurns bounds the number of model requests.
+	MaxTurns int
+	Format   string
+	// Output receives the result, diagnostics go to stderr.
+	Output io.Writer
+}
+
+// headlessResult is the final JSON object of a headless run.
+type headlessResult struct {
+	Type       string          `json:"type"`
+	Result     string          `json:"result"`
+	IsError    bool            `json:"is_error"`
+	Error      string          `json:"error,omitempty"`
+	ErrorKind  string          `json:"error_kind,omitempty"`
+	StopReason string          `json:"stop_reason"`
+	Turns      int             `json:"turns"`
+	ToolCalls  []toolCallEvent `json:"tool_calls"`
+	Usage      usageEvent      `json:"usage"`
+	DurationMS int64           `json:"duration_ms"`
+	SessionID  string          `json:"session_id,omitempty"`
+	Model      string          `json:"model"`
+}
+
+type toolCallEvent struct {
+	Type      string `json:"type,omitempty"`
+	Turn      int    `json:"turn,omitempty"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+	IsError   bool   `json:"is_error"`
+}
+
+type usageEvent struct {
+	Requests         int    `json:"requests,omitempty"`
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	CachedTokens     int    `json:"cached_tokens"`
+	LatencyMS        int64  `json:"latency_ms"`
+	// CostUSD is only set if the models have a price in the config.
+	CostUSD *float64 `json:"cost_usd,omitempty"`
+}
+
+// sessionUsageEvent returns the usage of the whole run.
+func (a *Agent) sessionUsageEvent() usageEvent {
+	t := a.usage.Session()
+	event := usageEvent{
+		Requests:         t.Requests,
+		PromptTokens:     t.PromptTokens,
+		CompletionTokens: t.CompletionTokens,
+		CachedTokens:     t.CachedTokens,
+		LatencyMS:        t.Latency.Milliseconds(),
+	}
+	if t.Priced() {
+		event.CostUSD = &t.Cost
+	}
+	return event
+}
+
+// requestUsageEvent returns the usage of the latest request.
+func (a *Agent) requestUsageEvent() usageEvent {
+	u := a.client.LastUsage()
+	event := usageEvent{
+		Model:            u.Model,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		CachedTokens:     u.CachedTokens,
+		LatencyMS:        u.Latency.Milliseconds(),
+	}
+	if cost, ok := a.usage.Cost(u); ok {
+		event.CostUSD = &cost
+	}
+	return event
+}
+
+// RunHeadless runs prompt to completion without the REPL: the model is
+// called until it answers without tool calls, or MaxTurns requests were
+// made. It returns the exit code of sous.
+func (a *Agent) RunHeadless(prompt string, opts headlessOptions) int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	emit := func(event any) {
+		if opts.Format == outputStreamJSON {
+			writeJSON(opts.Output, event)
+		}
+	}
+	sessionID := ""
+	if a.session != nil {
+		sessionID = a.session.ID
+	}
+	emit(map[string]any{
+		"type":       "system",
+		"session_id": sessionID,
+		"model":      a.client.Model(),
+		"cwd":        a.root,
+	})
+
+	a.conversation = withSystemPrompt(append([]openai.ChatCompletionMessageParamUnion{}, a.resumed...), a.systemPrompt)
+	if len(a.resumed) > 0 && a.session != nil {
+		a.session.Reset(0)
+	}
+	a.checkpoints.Begin(prompt, len(a.conversation))
+	a.guard.StartTurn()
+	a.usage.StartTurn()
+	a.conversation = append(a.conversation, openai.UserMessage(prompt))
+
+	result := headlessResult{
+		Type:       "result",
+		StopReason: "max_turns",
+		ToolCalls:  []toolCallEvent{},
+		SessionID:  sessionID,
+		Model:      a.client.Model(),
+	}
+	for result.Turns < opts.MaxTurns {
+		if a.history.NeedsCompaction(a.conversation) {
+			a.conversation = a.compact(ctx, a.conversation, "")
+		}
+		if err := a.guard.BeforeRequest(a.sessionTokens()); err != nil {
+			result.stopAt(err)
+			break
+		}
+		result.Turns++
+		turn := result.Turns
+		message, err := a.infer(ctx, func(delta string) {
+			emit(map[string]any{"type": "text_delta", "turn": turn, "text": delta})
+		})
+		if err != nil {
+			result.IsError, result.Error, result.StopReason = true, err.Error(), "error"
+			kind := client.KindOf(err)
+			result.ErrorKind = kind.String()
+			if ctx.Err() != nil || kind == client.KindCancelled {
+				result.StopReason = "interrupted"
+			}
+			break
+		}
+		result.Result = message.Content
+		calls := []map[string]string{}
+		for _, c := range message.ToolCalls {
+			calls = append(calls, map[string]string{"id": c.ID, "name": c.Function.Name, "arguments": c.Function.Arguments})
+		}
+		emit(map[string]any{
+			"type":       "assistant",
+			"turn":       turn,
+			"text":       message.Content,
+			"tool_calls": calls,
+			"usage":      a.requestUsageEvent(),
+		})
+		if len(message.ToolCalls) == 0 {
+			result.StopReason = "done"
+			break
+		}
+		if err := a.guard.CheckCalls(message.ToolCalls); err != nil {
+			a.skipTools(message.ToolCalls, err)
+			result.stopAt(err)
+			break
+		}
+		runs := a.runTools(ctx, message.ToolCalls)
+		for _, run := range runs {
+			event := toolCallEvent{
+				Type:      "tool_result",
+				Turn:      turn,
+				ID:        run.Call.ID,
+				Name:      run.Call.Function.Name,
+				Arguments: run.Call.Function.Arguments,
+				Result:    run.Result,
+				IsError:   run.Err != nil,
+			}
+			emit(event)
+			event.Type, event.Turn = "", 0
+			result.ToolCalls = append(result.ToolCalls, event)
+		}
+		if ctx.Err() != nil {
+			result.IsError, result.Error, result.StopReason = true, ctx.Err().Error(), "interrupted"
+			break
+		}
+		if err := a.checkResults(runs); err != nil {
+			result.stopAt(err)
+			break
+		}
+	}
+	if result.StopReason == "max_turns" {
+		result.IsError = true
+		result.Error = fmt.Sprintf("stopped after %d turns without a final answer", opts.MaxTurns)
+	}
+	result.Usage = a.sessionUsageEvent()
+	result.DurationMS = time.Since(start).Milliseconds()
+
+	switch opts.Format {
+	case outputText:
+		if result.Result != "" {
+			fmt.Fprintln(opts.Output, strings.TrimSpace(result.Result))
+		}
+	default:
+		writeJSON(opts.Output, result)
+	}
+	if result.Error != "" {
+		PrintAction("%s\n", result.Error)
+	}
+	switch result.StopReason {
+	case "done":
+		return exitOK
+	case "error":
+		return exitError
+	case "interrupted":
+		return exitInterrupted
+	}
+	return exitLimit
+}
+
+// stopAt records that a limit of the guard stopped the run, the stop
+// reason is the name of the limit.
+func (r *headlessResult) stopAt(err error) {
+	r.IsError, r.Error, r.StopReason = true, err.Error(), "error"
+	var limit *guard.LimitError
+	if errors.As(err, &limit) {
+		r.StopReason = limit.Limit
+	}
+}
+
+func writeJSON(w io.Writer, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		PrintAction("encoding the output failed: %s\n", err.Error())
+		return
+	}
+	fmt.Fprintf(w, "%s\n", data)
+}
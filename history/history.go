@@ -0,0 +1,236 @@
+package history
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/openai/openai-go"
+)
+
+type Message = openai.ChatCompletionMessageParamUnion
+
+// Manager keeps track of how much of the context window of the model the
+// conversation uses and decides when and how to compact it.
+type Manager struct {
+	// Window is the context window of the model in tokens.
+	Window int
+	// Threshold is the fraction of the window at which the conversation is
+	// compacted.
+	Threshold float64
+	// KeepTurns is the number of recent turns kept verbatim.
+	KeepTurns int
+
+	// reported is the number of prompt and completion tokens the backend
+	// reported for the first reportedLen messages.
+	reported    int
+	reportedLen int
+	pinned      map[int]bool
+	// compactedLen is the length of the conversation after the last
+	// compaction, it is not compacted again before it grew.
+	compactedLen int
+}
+
+func NewManager(window int, threshold float64) *Manager {
+	return &Manager{
+		Window:    window,
+		Threshold: threshold,
+		KeepTurns: 2,
+		pinned:    map[int]bool{},
+	}
+}
+
+// Observe records the token usage reported for a request that sent the
+// first conversationLen messages and got one message back.
+func (m *Manager) Observe(conversationLen int, promptTokens int, completionTokens int) {
+	if promptTokens == 0 {
+		// the backend does not report usage, the estimate is all we have
+		return
+	}
+	m.reported = promptTokens + completionTokens
+	m.reportedLen = conversationLen + 1
+}
+
+// Tokens returns the number of tokens the conversation uses. Messages
+// covered by the usage reported by the backend are counted exactly, later
+// ones are estimated.
+func (m *Manager) Tokens(conversation []Message) int {
+	if m.reportedLen == 0 || m.reportedLen > len(conversation) {
+		return Estimate(conversation)
+	}
+	return m.reported + Estimate(conversation[m.reportedLen:])
+}
+
+// Limit is the number of tokens at which the conversation is compacted.
+func (m *Manager) Limit() int {
+	return int(float64(m.Window) * m.Threshold)
+}
+
+// NeedsCompaction reports whether the conversation grew close to the
+// context window.
+func (m *Manager) NeedsCompaction(conversation []Message) bool {
+	if m.Window <= 0 || len(conversation) <= m.compactedLen {
+		return false
+	}
+	return m.Tokens(conversation) >= m.Limit()
+}
+
+// Pin keeps the message at index verbatim when the conversation is
+// compacted.
+func (m *Manager) Pin(index int) {
+	m.pinned[index] = true
+}
+
+// Unpin releases all pinned messages.
+func (m *Manager) Unpin() {
+	m.pinned = map[int]bool{}
+}
+
+// Pinned returns the indices of the pinned messages in order.
+func (m *Manager) Pinned() []int {
+	indices := make([]int, 0, len(m.pinned))
+	for i := range m.pinned {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// Truncated tells the manager that the conversation was cut down to its
+// first n messages, e.g. by a rewind.
+func (m *Manager) Truncated(n int) {
+	for i := range m.pinned {
+		if i >= n {
+			delete(m.pinned, i)
+		}
+	}
+	if m.reportedLen > n {
+		m.reported, m.reportedLen = 0, 0
+	}
+	m.compactedLen = min(m.compactedLen, n)
+}
+
+// Reset forgets everything known about the conversation, e.g. when a new
+// one starts.
+func (m *Manager) Reset() {
+	m.reported, m.reportedLen = 0, 0
+	m.compactedLen = 0
+	m.Unpin()
+}
+
+// Plan splits a conversation for compaction.
+type Plan struct {
+	// System are the system messages at the start of the conversation,
+	// kept verbatim.
+	System []Message
+	// Task is the first message of the user, kept verbatim.
+	Task Message
+	// Old are the messages to summarize.
+	Old []Message
+	// Pinned are messages kept verbatim, in their original order.
+	Pinned []Message
+	// Recent are the latest turns, kept verbatim.
+	Recent []Message
+
+	recentFrom int
+}
+
+// Plan decides which parts of the conversation are summarized. It returns
+// false if there is nothing old enough to summarize.
+func (m *Manager) Plan(conversation []Message) (Plan, bool) {
+	task := 0
+	for task < len(conversation) && conversation[task].OfSystem != nil {
+		task++
+	}
+	if len(conversation) < task+2 || conversation[task].OfUser == nil {
+		return Plan{}, false
+	}
+	start := m.recentStart(conversation, task)
+	if start <= task+1 {
+		return Plan{}, false
+	}
+	p := Plan{
+		System:     conversation[:task],
+		Task:       conversation[task],
+		Recent:     conversation[start:],
+		recentFrom: start,
+	}
+	for i := task + 1; i < start; i++ {
+		if m.pinned[i] {
+			p.Pinned = append(p.Pinned, conversation[i])
+		} else {
+			p.Old = append(p.Old, conversation[i])
+		}
+	}
+	if len(p.Old) == 0 {
+		return Plan{}, false
+	}
+	return p, true
+}
+
+// recentStart returns the index of the first message kept verbatim: the
+// start of the last KeepTurns turns, moved forward while the recent part
+// alone would take up more than half of the limit. The recent part always
+// starts with a user or an assistant message, so tool results stay with
+// their calls.
+func (m *Manager) recentStart(conversation []Message, task int) int {
+	starts := []int{}
+	turns := 0
+	for i := len(conversation) - 1; i > task; i-- {
+		msg := conversation[i]
+		if msg.OfUser != nil || msg.OfAssistant != nil {
+			starts = append(starts, i)
+		}
+		if msg.OfUser != nil {
+			turns++
+			if turns == m.KeepTurns {
+				break
+			}
+		}
+	}
+	// starts is ordered from the end, try the earliest first
+	for j := len(starts) - 1; j >= 0; j-- {
+		if m.Window == 0 || Estimate(conversation[starts[j]:]) <= m.Limit()/2 {
+			return starts[j]
+		}
+	}
+	return len(conversation)
+}
+
+// Apply builds the compacted conversation from the plan and the summary of
+// its old messages.
+func (m *Manager) Apply(p Plan, summary string) []Message {
+	conversation := append([]Message{}, p.System...)
+	conversation = append(conversation, p.Task, SummaryMessage(summary))
+	pinnedRecent := []int{}
+	for _, i := range m.Pinned() {
+		if i >= p.recentFrom {
+			pinnedRecent = append(pinnedRecent, i-p.recentFrom)
+		}
+	}
+	m.Reset()
+	for _, msg := range p.Pinned {
+		m.pinned[len(conversation)] = true
+		conversation = append(conversation, msg)
+	}
+	for _, i := range pinnedRecent {
+		m.pinned[len(conversation)+i] = true
+	}
+	conversation = append(conversation, p.Recent...)
+	m.compactedLen = len(conversation)
+	return conversation
+}
+
+// Estimate guesses the number of tokens of the messages at about four
+// bytes of JSON per token.
+func Estimate(messages []Message) int {
+	total := 0
+	for _, msg := range messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		// every message carries a few tokens of framing
+		total += len(data)/4 + 4
+	}
+	return total
+}
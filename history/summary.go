@@ -0,0 +1,95 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/moritz-tiesler/sous/client"
+	"github.com/openai/openai-go"
+)
+
+// summaryMarker starts every summary message, so summaries can be told
+// apart from messages of the user.
+const summaryMarker = "[Conversation summary]"
+
+// maxTranscriptResult bounds the length of a single tool result in the
+// transcript that is summarized.
+const maxTranscriptResult = 2000
+
+const summarySystemPrompt = `You summarize the conversation between a user and a coding assistant, so that the assistant can continue the work from the summary alone. You cannot call tools. Be concise and specific, keep file paths, function names, commands and error messages exactly as they appear. Answer with the summary only, using exactly these sections:
+
+## Goal
+What the user wants to achieve, including constraints and preferences they stated.
+
+## Decisions
+Decisions made so far and the reasons for them.
+
+## Files touched
+Every file that was read, created or modified, one per line, with a short note on what was done.
+
+## Pending steps
+What is left to do, in order. Mention anything that failed and still needs attention.`
+
+// SummaryRequest returns the conversation for a tool free request that
+// summarizes messages. focus adds instructions of the user on what the
+// summary should concentrate on.
+func SummaryRequest(messages []Message, focus string) []Message {
+	prompt := strings.Builder{}
+	prompt.WriteString("Summarize this conversation:\n\n<conversation>\n")
+	prompt.WriteString(Transcript(messages, maxTranscriptResult))
+	prompt.WriteString("</conversation>")
+	if focus = strings.TrimSpace(focus); focus != "" {
+		fmt.Fprintf(&prompt, "\n\nFocus the summary on: %s", focus)
+	}
+	return []Message{
+		openai.SystemMessage(summarySystemPrompt),
+		openai.UserMessage(prompt.String()),
+	}
+}
+
+// SummaryMessage returns the message that replaces the summarized part of
+// the conversation.
+func SummaryMessage(summary string) Message {
+	return openai.UserMessage(fmt.Sprintf(
+		"%s\nThe earlier part of this conversation was summarized to save space:\n\n%s",
+		summaryMarker, strings.TrimSpace(summary)))
+}
+
+// IsSummary reports whether msg was created by SummaryMessage.
+func IsSummary(msg Message) bool {
+	if msg.OfUser == nil {
+		return false
+	}
+	return strings.HasPrefix(msg.OfUser.Content.OfString.Value, summaryMarker)
+}
+
+// Transcript renders messages as plain text, so they can be summarized by
+// a request that knows nothing about tools. Tool results longer than
+// maxResult bytes are clipped, 0 keeps them whole.
+func Transcript(messages []Message, maxResult int) string {
+	sb := strings.Builder{}
+	for _, m := range messages {
+		w, err := client.DecodeMessage(m)
+		if err != nil {
+			continue
+		}
+		text := strings.TrimSpace(w.Content)
+		switch w.Role {
+		case "tool":
+			if maxResult > 0 && len(text) > maxResult {
+				text = text[:maxResult] + fmt.Sprintf("\n[... %d more bytes]", len(text)-maxResult)
+			}
+			fmt.Fprintf(&sb, "TOOL RESULT:\n%s\n\n", text)
+			continue
+		case "":
+			continue
+		}
+		if text != "" {
+			fmt.Fprintf(&sb, "%s:\n%s\n\n", strings.ToUpper(w.Role), text)
+		}
+		for _, tc := range w.ToolCalls {
+			fmt.Fprintf(&sb, "%s called %s(%s)\n\n", strings.ToUpper(w.Role), tc.Name, tc.Arguments)
+		}
+	}
+	return sb.String()
+}
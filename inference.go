@@ -0,0 +1,18 @@
+package main
+
+import "github.com/moritz-tiesler/sous/client"
+
+// reportInferenceError tells the user why the model did not answer. The
+// conversation is left as it was, so the user can simply try again.
+func reportInferenceError(err error) {
+	switch client.KindOf(err) {
+	case client.KindCancelled:
+		PrintAction("Cancelled\n")
+	case client.KindContextLength:
+		PrintAction("%s\nThe conversation does not fit into the context window, try /compact, /rewind or /clear\n", err.Error())
+	case client.KindNetwork:
+		PrintAction("%s\nThe server cannot be reached, check that it is running and try again\n", err.Error())
+	default:
+		PrintAction("The request failed: %s\n", err.Error())
+	}
+}
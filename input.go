@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// lineReader reads the input of the user. On a terminal it supports line
+// editing, a history and tab completion of commands, otherwise it reads
+// plain lines, e.g. from a pipe.
+type lineReader struct {
+	// Complete returns the completions of the command name prefix.
+	Complete func(prefix string) []string
+
+	fd       int
+	terminal *term.Terminal
+	scanner  *bufio.Scanner
+}
+
+func newLineReader() *lineReader {
+	r := &lineReader{fd: int(os.Stdin.Fd())}
+	if !term.IsTerminal(r.fd) {
+		r.scanner = bufio.NewScanner(os.Stdin)
+		return r
+	}
+	r.terminal = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	r.terminal.AutoCompleteCallback = r.autoComplete
+	return r
+}
+
+// ReadLine shows prompt and returns the next line. It returns false at the
+// end of the input, or when the user pressed Ctrl-C or Ctrl-D.
+func (r *lineReader) ReadLine(prompt string) (string, bool) {
+	if r.terminal == nil {
+		fmt.Print(prompt)
+		if !r.scanner.Scan() {
+			return "", false
+		}
+		return r.scanner.Text(), true
+	}
+	// the terminal is raw only while reading, so the output of the model
+	// and the tools is printed as usual
+	state, err := term.MakeRaw(r.fd)
+	if err != nil {
+		return "", false
+	}
+	defer term.Restore(r.fd, state)
+	if width, height, err := term.GetSize(r.fd); err == nil && width > 0 {
+		r.terminal.SetSize(width, height)
+	}
+	r.terminal.SetPrompt(prompt)
+	line, err := r.terminal.ReadLine()
+	if err != nil && !errors.Is(err, term.ErrPasteIndicator) {
+		return "", false
+	}
+	return line, true
+}
+
+// autoComplete completes the command name under the cursor on tab. With
+// several candidates it extends the name to their common prefix and lists
+// them.
+func (r *lineReader) autoComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || r.Complete == nil {
+		return "", 0, false
+	}
+	prefix := line[:pos]
+	if !strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, " \t") {
+		return "", 0, false
+	}
+	matches := r.Complete(prefix)
+	switch len(matches) {
+	case 0:
+		return "", 0, false
+	case 1:
+		completed := matches[0] + " "
+		rest := strings.TrimPrefix(line[pos:], " ")
+		return completed + rest, len(completed), true
+	}
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(prefix) {
+		return common + line[pos:], len(common), true
+	}
+	fmt.Fprintf(r.terminal, "%s\n", strings.Join(matches, "  "))
+	return line, pos, true
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -12,33 +12,144 @@ import (
 	"syscall"
 
 	"github.com/charmbracelet/glamour"
+	"github.com/fatih/color"
+	"github.com/moritz-tiesler/sous/checkpoint"
 	"github.com/moritz-tiesler/sous/client"
-	toolsopenai "github.com/moritz-tiesler/sous/tools_openai"
-	"github.com/ollama/ollama/api"
+	"github.com/moritz-tiesler/sous/command"
+	"github.com/moritz-tiesler/sous/config"
+	"github.com/moritz-tiesler/sous/guard"
+	"github.com/moritz-tiesler/sous/history"
+	"github.com/moritz-tiesler/sous/permission"
+	"github.com/moritz-tiesler/sous/sandbox"
+	"github.com/moritz-tiesler/sous/session"
+	"github.com/moritz-tiesler/sous/tools"
+	"github.com/moritz-tiesler/sous/usage"
 	"github.com/openai/openai-go"
+	"golang.org/x/term"
 )
 
 func main() {
-	// client, err := api.ClientFromEnvironment()
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
+	// sous re-executes itself to set up the shell sandbox
+	sandbox.Init()
+
+	flags := config.BindFlags(flag.CommandLine)
+	cont := flag.Bool("continue", false, "resume the latest session of this project")
+	var resume resumeFlag
+	flag.Var(&resume, "resume", "resume the session with the given id, without an id pick one from a list")
+	prompt := flag.String("p", "", "run the prompt without the REPL and exit, piped input is appended to it")
+	maxTurns := flag.Int("max-turns", 25, "the number of model requests after which a run with -p stops")
+	format := flag.String("output-format", outputText, "the output of a run with -p: text, json or stream-json")
+	flag.Parse()
+	args := flag.Args()
+	// -resume is a boolean flag, so parsing stops at its id, the flags
+	// after it are parsed once the id is taken
+	if resume.set && resume.id == "" && len(args) > 0 && args[0] != "config" {
+		resume.id = args[0]
+		flag.CommandLine.Parse(args[1:])
+		args = flag.Args()
+	}
+	cfg, err := config.Load(flags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 {
+		if len(args) == 2 && args[0] == "config" && args[1] == "show" {
+			cfg.Show(os.Stdout)
+			return
+		}
+		log.Fatalf("unknown command %q", strings.Join(args, " "))
+	}
+	headlessInput, headless, err := headlessPrompt(*prompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	results := os.Stdout
+	if headless {
+		if err := checkOutputFormat(*format); err != nil {
+			log.Fatal(err)
+		}
+		if *maxTurns < 1 {
+			log.Fatalf("-max-turns must be at least 1")
+		}
+		// stdout carries the result only, everything else goes to stderr
+		os.Stdout = os.Stderr
+		color.Output = os.Stderr
+		color.NoColor = !term.IsTerminal(int(os.Stderr.Fd()))
+	}
 
-	client := client.New("Qwen3-14B-128K-GGUF_Qwen3-14B-128K-UD-Q6_K_XL")
+	client, err := client.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client.Log = func(format string, args ...any) {
+		PrintAction("\n"+format+"\n", args...)
+	}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	getUserMessage := func() (string, bool) {
-		if !scanner.Scan() {
-			return "", false
-		}
-		return scanner.Text(), true
+	var input *lineReader
+	readLine := func(string) (string, bool) { return "", false }
+	var ask func(string) permission.Answer
+	if !headless {
+		input = newLineReader()
+		readLine = input.ReadLine
+		ask = askPermission(readLine)
+	}
+	// without a REPL there is nobody to ask, calls that need confirmation
+	// are denied
+	perms, err := permission.New(".", ask)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sess, resumed, err := openSession(session.Dir(root), cfg.Model, *cont, resume, readLine)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	registry := tools.Default()
+	registry.Ignore = cfg.Ignore
+	registry.ShellTimeout = cfg.ShellTimeout
+	registry.Sandbox = sandbox.Options{
+		Mode:    cfg.Sandbox,
+		Root:    root,
+		Network: cfg.SandboxNetwork,
+	}
+	registry.CheckPath = perms.CheckPath
+	registry.OnFileChange = func(change tools.FileChange) {
+		PrintDiff(tools.UnifiedDiff(change.Path, string(change.Before), string(change.After)))
+	}
 	agent := NewAgent(
-		client, getUserMessage,
-		toolsopenai.Tools(),
-		toolsopenai.ToolMap(),
+		client, readLine,
+		registry,
+		perms,
 	)
+	agent.root = root
+	agent.session = sess
+	agent.resumed = resumed
+	agent.systemPrompt = systemPrompt(root, registry)
+	agent.history = history.NewManager(cfg.ContextWindow, cfg.CompactThreshold)
+	agent.guard = guard.New(guard.Limits{
+		ToolRounds:    cfg.MaxToolRounds,
+		TurnTime:      cfg.MaxTurnTime,
+		SessionTokens: cfg.MaxSessionTokens,
+		RepeatedCalls: cfg.MaxRepeatedCalls,
+	})
+	agent.guard.Polling = map[string]bool{tools.SESSION_READ: true, tools.SESSION_LIST: true}
+	agent.usage = usage.NewTracker(cfg.Prices)
+	if headless {
+		code := agent.RunHeadless(headlessInput, headlessOptions{
+			MaxTurns: *maxTurns,
+			Format:   *format,
+			Output:   results,
+		})
+		agent.Close()
+		os.Exit(code)
+	}
+	agent.loadCustomCommands()
+	input.Complete = agent.commands.Complete
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
@@ -65,26 +176,61 @@ func main() {
 		err := agent.Run(appCtx)
 		if err != nil {
 			fmt.Printf("Error: %s\n", err.Error())
-			appCancel()
 		}
+		appCancel()
 	}()
 
 	<-appCtx.Done()
+	agent.Close()
+	agent.printSessionUsage()
 	fmt.Println("Bye")
 	os.Exit(1)
 }
 
 func NewAgent(
 	client *client.Client,
-	getUserMessage func() (string, bool),
-	toolDefs []openai.ChatCompletionToolParam,
-	toolMap map[string]func(string) (string, error),
+	getUserMessage func(prompt string) (string, bool),
+	registry *tools.Registry,
+	permissions *permission.Checker,
 ) *Agent {
-	return &Agent{
+	a := &Agent{
 		client:       client,
 		getUserInput: getUserMessage,
-		toolDefs:     toolDefs,
-		toolMap:      toolMap,
+		tools:        registry,
+		permissions:  permissions,
+		sessions:     tools.NewSessionPool(),
+		checkpoints:  checkpoint.NewStore(),
+		commands:     command.NewRegistry(),
+	}
+	a.registerCommands()
+	registry.Sessions = a.sessions
+	// every file change made by a tool is recorded for /undo
+	onFileChange := registry.OnFileChange
+	registry.OnFileChange = func(change tools.FileChange) {
+		a.checkpoints.Record(change)
+		if onFileChange != nil {
+			onFileChange(change)
+		}
+	}
+	return a
+}
+
+// Close stops the shell sessions the model left running and closes the
+// session file.
+func (a *Agent) Close() {
+	a.sessions.Close()
+	if a.session != nil {
+		a.session.Close()
+	}
+}
+
+// saveSession appends the new messages of conversation to the session file.
+func (a *Agent) saveSession(conversation []openai.ChatCompletionMessageParamUnion) {
+	if a.session == nil {
+		return
+	}
+	if err := a.session.Sync(conversation); err != nil {
+		PrintAction("saving the session failed: %s\n", err.Error())
 	}
 }
 
@@ -95,81 +241,98 @@ type ChatContext struct {
 
 type Agent struct {
 	client       *client.Client
-	getUserInput func() (string, bool)
-	toolDefs     []openai.ChatCompletionToolParam
-	toolMap      map[string]func(string) (string, error)
+	getUserInput func(prompt string) (string, bool)
+	tools        *tools.Registry
+	permissions  *permission.Checker
+	sessions     *tools.SessionPool
+	checkpoints  *checkpoint.Store
+	commands     *command.Registry
+	// root is the working directory
+	root string
+	// conversation is the current conversation
+	conversation []openai.ChatCompletionMessageParamUnion
+	// session stores the conversation, resumed is the conversation of a
+	// resumed session
+	session *session.Session
+	resumed []openai.ChatCompletionMessageParamUnion
+	history *history.Manager
+	guard   *guard.Guard
+	// systemPrompt is the first message of every conversation
+	systemPrompt string
+	// usage sums up the tokens and cost of the requests
+	usage *usage.Tracker
 }
 
 const PREFIX = "\u001b[93mSous\u001b[0m: %s"
 
-func dumpConvo(convo []openai.ChatCompletionMessageParamUnion) string {
-	sb := strings.Builder{}
-	for _, m := range convo {
-		b, err := json.MarshalIndent(m, "", "    ")
-		if err != nil {
-			panic(fmt.Sprintf("error unmarshaling %v", m))
-		}
-		sb.Write(b)
-	}
-	return sb.String()
-}
-
 func (a *Agent) Run(ctx context.Context) error {
-	conversation := []openai.ChatCompletionMessageParamUnion{}
-	fmt.Println("Chat with Sous")
+	a.conversation = append([]openai.ChatCompletionMessageParamUnion{}, a.resumed...)
+	fmt.Println("Chat with Sous, /help lists the commands")
+	if len(a.conversation) > 0 && a.session != nil {
+		PrintAction("Resumed session %s with %d messages\n", a.session.ID, len(a.conversation))
+		// the stored system prompt is replaced by the current one
+		a.session.Reset(0)
+	}
+	a.conversation = withSystemPrompt(a.conversation, a.systemPrompt)
 
 	// stream := true
 	readUserInput := true
 	for {
-		if len(conversation) > 4 {
-			PrintAction("%s...\n", "SUMMARIZING")
-			summary, err := a.summarizeConvo(ctx, conversation)
-
-			if err != nil {
-				fmt.Println("error after summarizeConvo")
-				fmt.Println(dumpConvo(conversation))
-				fmt.Println(err.Error())
-			} else {
-				conversation = append([]openai.ChatCompletionMessageParamUnion{}, summary.ToParam())
-				PrintAction("NEW CONVO LEN=%d...\n", len(conversation))
-				PrintAction("NEW CONVO STarts with=%s...\n", summary.Content)
-			}
+		if a.history.NeedsCompaction(a.conversation) {
+			a.conversation = a.compact(ctx, a.conversation, "")
 		}
 		if readUserInput {
-			fmt.Print("\u001b[94mYou\u001b[0m: ")
-			userInput, ok := a.getUserInput()
+			userInput, ok := a.getUserInput("\u001b[94mYou\u001b[0m: ")
 			if !ok {
 				break
 			}
+			label := userInput
+			if result, handled := a.runCommand(ctx, userInput); handled {
+				if result.Quit {
+					break
+				}
+				if result.Prompt == "" {
+					continue
+				}
+				userInput = result.Prompt
+			}
+			a.checkpoints.Begin(label, len(a.conversation))
+			a.guard.StartTurn()
+			a.usage.StartTurn()
 			userMessage := openai.UserMessage(userInput)
-			conversation = append(conversation, userMessage)
+			a.conversation = append(a.conversation, userMessage)
+		}
+		if err := a.guard.BeforeRequest(a.sessionTokens()); err != nil && !a.offerContinue(err) {
+			readUserInput = true
+			continue
 		}
 
-		message, err := a.client.RunInference(ctx, conversation, a.toolDefs)
+		fmt.Printf(PREFIX, "")
+		printer := newStreamPrinter(len("Sous: "))
+		logf := a.client.Log
+		a.client.Log = printer.Log
+		message, err := a.infer(ctx, printer.Write)
+		a.client.Log = logf
+		printer.Clear()
 		if err != nil {
-			fmt.Println("error after RunInference")
-			fmt.Println(len(conversation))
-			fmt.Println(dumpConvo(conversation))
-			fmt.Println(err.Error())
+			reportInferenceError(err)
+			readUserInput = true
+			continue
 		}
-		conversation = append(conversation, message.ToParam())
-
-		toolResults := []openai.ChatCompletionMessageParamUnion{}
 
 		fmt.Printf(PREFIX, "")
-		// TODO print code md snippets as md
-		// PrintNonThink("%s\n", message.Content)
 		out, err := glamour.Render(message.Content, "dracula")
 		if err != nil {
 			panic(err)
 		}
 		fmt.Print(out)
-		for _, toolCall := range message.ToolCalls {
-			f := toolCall.Function
-			result, _ := a.executeTool(toolCall.ID, f.Name, f.Arguments)
-			toolResults = append(toolResults, result)
+		if err := a.guard.CheckCalls(message.ToolCalls); err != nil && !a.offerContinue(err) {
+			a.skipTools(message.ToolCalls, err)
+			readUserInput = true
+			continue
 		}
-		if len(toolResults) == 0 {
+		runs := a.runTools(ctx, message.ToolCalls)
+		if len(runs) == 0 {
 			readUserInput = true
 			go func() {
 				if err := ping(); err != nil {
@@ -178,127 +341,127 @@ func (a *Agent) Run(ctx context.Context) error {
 			}()
 			continue
 		}
+		if err := a.checkResults(runs); err != nil && !a.offerContinue(err) {
+			readUserInput = true
+			continue
+		}
 
 		readUserInput = false
-		conversation = append(conversation, toolResults...)
+	}
+	return nil
+}
 
-		if len(conversation) < 1 {
-			panic("why conve len=0?????")
+// infer sends the conversation to the model and appends the response to
+// it. onDelta receives the content as it streams in. A conversation that
+// is too long for the model is compacted once before giving up, the client
+// retries transient failures itself. A failed request leaves the
+// conversation as it is.
+func (a *Agent) infer(ctx context.Context, onDelta func(string)) (openai.ChatCompletionMessage, error) {
+	compacted := false
+	for {
+		a.saveSession(a.conversation)
+		message, err := a.client.RunInferenceStream(ctx, a.conversation, a.tools, onDelta)
+		usage := a.client.LastUsage()
+		a.addUsage(usage, err)
+		if err == nil {
+			a.history.Observe(len(a.conversation), usage.PromptTokens, usage.CompletionTokens)
+			a.conversation = append(a.conversation, message.ToParam())
+			a.saveSession(a.conversation)
+			return message, nil
 		}
-
+		if client.KindOf(err) == client.KindContextLength && !compacted {
+			compacted = true
+			PrintAction("\n%s\n", err.Error())
+			n := len(a.conversation)
+			if a.conversation = a.compact(ctx, a.conversation, ""); len(a.conversation) < n {
+				continue
+			}
+		}
+		return message, err
 	}
-	return nil
 }
 
-func (a *Agent) summarizeConvo(
-	ctx context.Context,
-	conversation []openai.ChatCompletionMessageParamUnion,
-) (openai.ChatCompletionMessage, error) {
+// toolRun is the outcome of a tool call.
+type toolRun struct {
+	Call   openai.ChatCompletionMessageToolCall
+	Result string
+	Err    error
+}
 
-	userMessage := openai.UserMessage(
-		"please summarize the active conversation, so that you can pick up your work form here. include the original user instructions so that you do not loose the context of the task at hand. include previous tool calls in this summary.",
-	)
-	conversation = append(conversation, userMessage)
+// runTools executes the tool calls of the model in order and appends their
+// results to the conversation.
+func (a *Agent) runTools(ctx context.Context, calls []openai.ChatCompletionMessageToolCall) []toolRun {
+	runs := []toolRun{}
+	for _, call := range calls {
+		f := call.Function
+		message, err := a.executeTool(ctx, call.ID, f.Name, f.Arguments)
+		a.conversation = append(a.conversation, message)
+		runs = append(runs, toolRun{
+			Call:   call,
+			Result: message.OfTool.Content.OfString.Value,
+			Err:    err,
+		})
+	}
+	return runs
+}
 
-	summary, err := a.client.RunInference(ctx, conversation, a.toolDefs)
-	return summary, err
+// skipTools answers the tool calls of the model with the reason they did
+// not run, the conversation would be invalid without the answers.
+func (a *Agent) skipTools(calls []openai.ChatCompletionMessageToolCall, reason error) {
+	for _, call := range calls {
+		a.conversation = append(a.conversation,
+			openai.ToolMessage(fmt.Sprintf("the call was not run: %s", reason.Error()), call.ID))
+	}
+	a.saveSession(a.conversation)
 }
 
-func (a *Agent) executeTool(id string, name string, args string) (openai.ChatCompletionMessageParamUnion, error) {
-	var toolFunc func(string) (string, error)
-	var found bool
-	for n, f := range a.toolMap {
-		if n == name {
-			toolFunc = f
-			found = true
-			break
-		}
+// executeTool runs a tool call and returns the message with its result. The
+// error tells whether the call failed or was denied, the message carries
+// the error text for the model in that case.
+func (a *Agent) executeTool(ctx context.Context, id string, name string, args string) (openai.ChatCompletionMessageParamUnion, error) {
+	if _, found := a.tools.Get(name); !found {
+		err := fmt.Errorf("tool '%s' not found", name)
+		return openai.ToolMessage(err.Error(), id), err
 	}
-	if !found {
-		return openai.ToolMessage("tool '%s' not found", id), nil
+	if err := a.permissions.Check(name, args); err != nil {
+		PrintAction("%s\n", err.Error())
+		return openai.ToolMessage(err.Error(), id), err
 	}
-	response, err := toolFunc(args)
+	// the tool runs under the chat context, so an interrupt cancels the
+	// tool instead of quitting sous
+	toolCtx, toolCancel := context.WithCancel(ctx)
+	a.client.SetActiveChatContext(toolCtx, toolCancel)
+	response, err := a.tools.Call(toolCtx, name, args)
+	a.client.ClearChatContext()
+	toolCancel()
 	PrintAction("tool: %s, %v\n%v\n", name, args, response)
 	if err != nil {
 		PrintAction("errors %s %v\n", response, err.Error())
-		return openai.ToolMessage(err.Error(), id), nil
+		return openai.ToolMessage(err.Error(), id), err
 	}
 	return openai.ToolMessage(response, id), nil
 }
 
-func (a *Agent) runInference(
-	ctx context.Context,
-	conversation []openai.ChatCompletionMessageParamUnion,
-	// stream bool,
-) (openai.ChatCompletionMessage, error) {
-
-	reqCtx, reqCancel := context.WithCancel(ctx)
-	a.client.SetActiveChatContext(reqCtx, reqCancel)
-	// req := &api.ChatRequest{
-	// 	// Model:  "gemma2",
-	// 	// Model:    "devstral:24b-small-2505-q8_0",
-	// 	// Model:    "llama3.2:latest",
-	// 	// Model:    "qwen2.5-coder:32b",
-	// 	// Model:    "qwen3:32b",
-	// 	// Model:    "qwen3:14b",
-	// 	Model:    "qwen3:14b_devstral",
-	// 	Messages: conversation,
-	// 	Stream:   &stream,
-	// 	Tools:    a.toolDefs,
-	// }
-	// content := strings.Builder{}
-	// message := api.Message{
-	// 	Role:      "assistant",
-	// 	ToolCalls: []api.ToolCall{},
-	// }
-	// thinkingOutput := false
-	// respFunc := func(cr api.ChatResponse) error {
-	// 	select {
-	// 	case <-reqCtx.Done():
-	// 		return fmt.Errorf("chat cancelled")
-	// 	default:
-	// 	}
-
-	// 	if strings.TrimSpace(cr.Message.Content) == "<think>" {
-	// 		thinkingOutput = true
-	// 	}
-	// 	var printFunc func(format string, a ...interface{})
-	// 	if thinkingOutput {
-	// 		printFunc = PrintThink
-	// 	} else {
-	// 		printFunc = PrintNonThink
-	// 	}
-	// 	if !cr.Done {
-	// 		printFunc("%s", cr.Message.Content)
-	// 	} else {
-	// 		fmt.Println()
-	// 	}
-	// 	if len(cr.Message.ToolCalls) > 0 {
-	// 		message.ToolCalls = append(message.ToolCalls, cr.Message.ToolCalls...)
-	// 	}
-	// 	content.WriteString(cr.Message.Content)
-	// 	if strings.TrimSpace(cr.Message.Content) == "</think>" {
-	// 		thinkingOutput = false
-	// 	}
-	// 	return nil
-	// }
-
-	// fmt.Printf(PREFIX, "")
-	// err := a.client.Chat(reqCtx, req, respFunc)
-	// message.Content = content.String()
-	message, _ := a.client.RunInference(ctx, conversation, a.toolDefs)
-	a.client.ClearChatContext()
-	return message, nil //, err
-}
-
-func dumpRequest(r api.ChatRequest) string {
-	sb := strings.Builder{}
-	b, err := json.MarshalIndent(r, "", "    ")
-	if err != nil {
-		panic(fmt.Sprintf("error unmarshaling %v", r))
+// askPermission returns a prompt for the permission checker that reads the
+// answer from the same input as the chat.
+func askPermission(getUserInput func(prompt string) (string, bool)) func(string) permission.Answer {
+	return func(question string) permission.Answer {
+		for {
+			PrintAction("%s ", question)
+			answer, ok := getUserInput("[y]es/[n]o/[a]lways: ")
+			if !ok {
+				return permission.No
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "y", "yes":
+				return permission.Yes
+			case "n", "no", "":
+				return permission.No
+			case "a", "always":
+				return permission.Always
+			}
+		}
 	}
-	sb.Write(b)
-	return sb.String()
 }
 
 func ping() error {
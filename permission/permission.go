@@ -0,0 +1,348 @@
+package permission
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Mode string
+
+const (
+	Allow Mode = "allow"
+	Ask   Mode = "ask"
+	Deny  Mode = "deny"
+)
+
+// Policy decides which tool calls need confirmation. It is stored per
+// project in .sous/permissions.json.
+type Policy struct {
+	// Tools maps tool names to their mode. Tools not listed use Default.
+	Tools   map[string]Mode `json:"tools"`
+	Default Mode            `json:"default"`
+	Shell   ShellPolicy     `json:"shell"`
+}
+
+// ShellPolicy lists shell commands that are always allowed or denied.
+// Entries are either a command prefix, e.g. "go test", or a glob where *
+// matches anything, e.g. "git * --force".
+type ShellPolicy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// ShellTool is the name of the tool whose command is matched against the
+// shell allow and deny lists.
+const ShellTool = "shell"
+
+// commandArgs maps the tools that run shell commands to the argument
+// holding the command. All of them are subject to the shell allow and deny
+// lists.
+var commandArgs = map[string]string{
+	ShellTool:      "command",
+	"sessionStart": "command",
+	"sessionSend":  "input",
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Tools: map[string]Mode{
+			"readFile":   Allow,
+			"searchFile": Allow,
+			"listFiles":  Allow,
+			"grep":       Allow,
+			// the session tools only act on sessions the agent started,
+			// starting and sending input is subject to the shell lists
+			"sessionRead": Allow,
+			"sessionList": Allow,
+			"sessionKill": Allow,
+			"writeFile":   Ask,
+			"createFile":  Ask,
+			"editFile":    Ask,
+			"applyPatch":  Ask,
+			ShellTool:     Ask,
+		},
+		Default: Ask,
+	}
+}
+
+// PolicyPath returns the location of the policy file of the project in dir.
+func PolicyPath(dir string) string {
+	return filepath.Join(dir, ".sous", "permissions.json")
+}
+
+// Answer is the reply of the user to a permission prompt.
+type Answer int
+
+const (
+	No Answer = iota
+	Yes
+	Always
+)
+
+// Checker enforces a policy. Calls that need confirmation are passed to
+// prompt; without a prompt they are denied.
+type Checker struct {
+	mu     sync.Mutex
+	policy Policy
+	path   string
+	root   string
+	prompt func(question string) Answer
+}
+
+// New loads the policy of the project in root. A missing policy file
+// yields the default policy.
+func New(root string, prompt func(question string) Answer) (*Checker, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	c := &Checker{
+		policy: DefaultPolicy(),
+		path:   PolicyPath(abs),
+		root:   abs,
+		prompt: prompt,
+	}
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.path, err)
+	}
+	if c.policy.Tools == nil {
+		c.policy.Tools = map[string]Mode{}
+	}
+	if c.policy.Default == "" {
+		c.policy.Default = Ask
+	}
+	return c, nil
+}
+
+// DeniedError is returned for tool calls that are not allowed to run.
+type DeniedError struct {
+	Tool   string
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("permission denied for %s: %s", e.Tool, e.Reason)
+}
+
+// Check decides whether the tool call may run, asking the user if the
+// policy says so.
+func (c *Checker) Check(tool string, arguments string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mode := c.modeOf(tool)
+	command := ""
+	if arg, ok := commandArgs[tool]; ok {
+		command = shellCommand(arguments, arg)
+		switch {
+		case matchesAny(c.policy.Shell.Deny, command, true):
+			return &DeniedError{Tool: tool, Reason: "the command is on the deny list"}
+		case mode != Deny && matchesAny(c.policy.Shell.Allow, command, false):
+			return nil
+		case mode != Deny && command == "":
+			// e.g. a session started without a command, nothing runs yet
+			return nil
+		}
+	}
+
+	switch mode {
+	case Allow:
+		return nil
+	case Deny:
+		return &DeniedError{Tool: tool, Reason: "the tool is disabled"}
+	}
+	if c.prompt == nil {
+		return &DeniedError{Tool: tool, Reason: "the call needs confirmation, but nobody can be asked"}
+	}
+
+	question := fmt.Sprintf("Allow %s %s?", tool, summarize(arguments))
+	switch {
+	case tool == ShellTool:
+		question = fmt.Sprintf("Allow shell command `%s`?", command)
+	case command != "":
+		question = fmt.Sprintf("Allow %s `%s`?", tool, command)
+	}
+	switch c.prompt(question) {
+	case Yes:
+		return nil
+	case Always:
+		if compound(command) {
+			// allow list entries never match compound commands, so there
+			// is nothing to remember
+			return nil
+		}
+		if command != "" {
+			c.policy.Shell.Allow = append(c.policy.Shell.Allow, command)
+		} else {
+			c.policy.Tools[tool] = Allow
+		}
+		if err := c.save(); err != nil {
+			return fmt.Errorf("allowed, but saving %s failed: %w", c.path, err)
+		}
+		return nil
+	}
+	return &DeniedError{Tool: tool, Reason: "the user rejected the call"}
+}
+
+// CheckPath ensures that a file tool only writes inside the project.
+func (c *Checker) CheckPath(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	abs = resolveExisting(abs)
+	rel, err := filepath.Rel(c.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &DeniedError{Tool: "file access", Reason: fmt.Sprintf("%s is outside of the working directory %s", path, c.root)}
+	}
+	return nil
+}
+
+// resolveExisting resolves symlinks in the longest existing prefix of path,
+// so links pointing out of the project are caught.
+func resolveExisting(path string) string {
+	rest := []string{}
+	for p := path; ; p = filepath.Dir(p) {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		if filepath.Dir(p) == p {
+			return path
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+	}
+}
+
+// ModeOf returns the mode the policy assigns to tool.
+func (c *Checker) ModeOf(tool string) Mode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.modeOf(tool)
+}
+
+func (c *Checker) modeOf(tool string) Mode {
+	if m, ok := c.policy.Tools[tool]; ok {
+		return m
+	}
+	return c.policy.Default
+}
+
+func (c *Checker) save() error {
+	data, err := json.MarshalIndent(c.policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
+
+func shellCommand(arguments string, arg string) string {
+	var args map[string]any
+	json.Unmarshal([]byte(arguments), &args)
+	command, _ := args[arg].(string)
+	return strings.TrimSpace(command)
+}
+
+// compound reports whether a command chains several commands, in which
+// case an allow list prefix says nothing about the rest of it.
+func compound(command string) bool {
+	return strings.ContainsAny(command, ";&|`<>\n") || strings.Contains(command, "$(")
+}
+
+// matchesAny matches command against the list entries. For deny lists
+// every part of a compound command is checked, allow lists never match
+// compound commands.
+func matchesAny(entries []string, command string, deny bool) bool {
+	parts := []string{command}
+	if compound(command) {
+		if !deny {
+			return false
+		}
+		parts = append(parts, strings.FieldsFunc(command, func(r rune) bool {
+			return strings.ContainsRune(";&|`<>\n()$", r)
+		})...)
+	}
+	for _, e := range entries {
+		for _, p := range parts {
+			if matchCommand(strings.TrimSpace(e), strings.TrimSpace(p)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchCommand(entry string, command string) bool {
+	if entry == "" {
+		return false
+	}
+	if strings.ContainsAny(entry, "*?") {
+		return wildcard(entry, command)
+	}
+	return command == entry || strings.HasPrefix(command, entry+" ")
+}
+
+// wildcard matches s against a pattern where * matches any sequence and ?
+// any single character, including spaces and slashes.
+func wildcard(pattern string, s string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(s); i++ {
+			if wildcard(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return s != "" && wildcard(pattern[1:], s[1:])
+	}
+	return s != "" && s[0] == pattern[0] && wildcard(pattern[1:], s[1:])
+}
+
+func summarize(arguments string) string {
+	const limit = 300
+	var args map[string]any
+	if json.Unmarshal([]byte(arguments), &args) != nil {
+		return arguments
+	}
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		s := fmt.Sprint(args[k])
+		if len(s) > 80 {
+			s = s[:80] + "..."
+		}
+		parts = append(parts, fmt.Sprintf("%s=%q", k, s))
+	}
+	out := strings.Join(parts, " ")
+	if len(out) > limit {
+		out = out[:limit] + "..."
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// InstructionFile is the name of the files with project instructions.
+const InstructionFile = "SOUS.md"
+
+// maxInstructionSize bounds the size of a single instruction file.
+const maxInstructionSize = 64 << 10
+
+const base = `You are Sous, a coding agent running in the terminal of a developer. You help with software engineering tasks in the project in the working directory: reading and explaining code, fixing bugs, implementing features and running commands.
+
+- Use the tools to look at the code before you change it, do not guess file contents.
+- Prefer small, focused edits over rewriting whole files, and keep the style of the surrounding code.
+- Run the build or the tests after a change when the project has them, and fix what you broke.
+- Ask the user when a request is ambiguous instead of making large assumptions.
+- Keep answers short, the user reads them in a terminal. Use markdown.`
+
+// Options describe the environment the system prompt is built for.
+type Options struct {
+	// Root is the working directory.
+	Root string
+	// Tools are the names of the available tools.
+	Tools []string
+	// GlobalDir holds the instruction file that applies to all projects,
+	// e.g. ~/.config/sous.
+	GlobalDir string
+}
+
+// Prompt is a built system prompt.
+type Prompt struct {
+	Text string
+	// Files are the instruction files included, in the order they appear.
+	Files []string
+}
+
+// Build composes the system prompt from the base prompt, facts about the
+// environment and the instruction files. The global instruction file comes
+// first, followed by the SOUS.md files from the file system root down to
+// the working directory, so more specific instructions come last. Files
+// that cannot be read are left out and reported in the error.
+func Build(opts Options) (Prompt, error) {
+	p := Prompt{}
+	sb := strings.Builder{}
+	sb.WriteString(base)
+	sb.WriteString("\n\n# Environment\n\n")
+	sb.WriteString(environment(opts))
+
+	files := []string{}
+	if opts.GlobalDir != "" {
+		files = append(files, filepath.Join(opts.GlobalDir, InstructionFile))
+	}
+	files = append(files, projectFiles(opts.Root)...)
+	errs := []error{}
+	for _, file := range files {
+		text, err := readInstructions(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n\n# Instructions from %s\n\n%s", file, strings.TrimSpace(text))
+		p.Files = append(p.Files, file)
+	}
+	p.Text = sb.String()
+	return p, errors.Join(errs...)
+}
+
+func environment(opts Options) string {
+	facts := []string{
+		"Working directory: " + opts.Root,
+		fmt.Sprintf("Operating system: %s/%s", runtime.GOOS, runtime.GOARCH),
+		"Date: " + time.Now().Format("2006-01-02"),
+	}
+	if branch, ok := gitBranch(opts.Root); ok {
+		facts = append(facts, "Git branch: "+branch)
+	} else {
+		facts = append(facts, "The working directory is not a git repository.")
+	}
+	if len(opts.Tools) > 0 {
+		facts = append(facts, "Tools: "+strings.Join(opts.Tools, ", "))
+	}
+	return "- " + strings.Join(facts, "\n- ")
+}
+
+// projectFiles returns the possible instruction files from the root of the
+// file system down to dir.
+func projectFiles(dir string) []string {
+	files := []string{}
+	for {
+		files = append([]string{filepath.Join(dir, InstructionFile)}, files...)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return files
+		}
+		dir = parent
+	}
+}
+
+func readInstructions(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxInstructionSize {
+		return "", fmt.Errorf("%s is larger than %d bytes", file, maxInstructionSize)
+	}
+	return string(data), nil
+}
+
+// gitBranch finds the git repository dir belongs to and returns the
+// checked out branch, or the commit for a detached head.
+func gitBranch(dir string) (string, bool) {
+	for {
+		gitDir := filepath.Join(dir, ".git")
+		info, err := os.Stat(gitDir)
+		if err == nil {
+			if !info.IsDir() {
+				// worktrees and submodules point to the real git dir
+				data, err := os.ReadFile(gitDir)
+				if err != nil {
+					return "", false
+				}
+				target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+				if !ok {
+					return "", false
+				}
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(dir, target)
+				}
+				gitDir = target
+			}
+			return headOf(gitDir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+func headOf(gitDir string) (string, bool) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", false
+	}
+	head := strings.TrimSpace(string(data))
+	if ref, ok := strings.CutPrefix(head, "ref: "); ok {
+		return strings.TrimPrefix(ref, "refs/heads/"), true
+	}
+	if len(head) > 12 {
+		head = head[:12]
+	}
+	return "detached at " + head, true
+}
@@ -0,0 +1,22 @@
+package sandbox
+
+// Options configure how shell commands are isolated.
+type Options struct {
+	// Mode is one of "off", "auto", "bwrap" or "namespace". auto uses
+	// bubblewrap when it is installed and plain namespaces otherwise.
+	Mode string
+	// Root is the project directory, the only writable part of the file
+	// system inside the sandbox.
+	Root string
+	// Network keeps network access inside the sandbox.
+	Network bool
+}
+
+// Enabled reports whether commands run in a sandbox.
+func (o Options) Enabled() bool {
+	return o.Mode != "" && o.Mode != "off"
+}
+
+// initArg marks a re-execution of sous that sets up the namespace sandbox
+// and then executes the command.
+const initArg = "__sous-sandbox-init"
@@ -0,0 +1,156 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Command returns a command running script with bash inside the sandbox
+// described by opts.
+func Command(ctx context.Context, opts Options, script string) (*exec.Cmd, error) {
+	if !opts.Enabled() {
+		return exec.CommandContext(ctx, "bash", "-c", script), nil
+	}
+	root, err := filepath.Abs(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+	mode := opts.Mode
+	if mode == "auto" {
+		mode = "namespace"
+		if _, err := exec.LookPath("bwrap"); err == nil {
+			mode = "bwrap"
+		}
+	}
+	switch mode {
+	case "bwrap":
+		return bwrapCommand(ctx, root, opts.Network, script)
+	case "namespace":
+		return namespaceCommand(ctx, root, opts.Network, script)
+	}
+	return nil, fmt.Errorf("unknown sandbox mode %q", opts.Mode)
+}
+
+func bwrapCommand(ctx context.Context, root string, network bool, script string) (*exec.Cmd, error) {
+	bwrap, err := exec.LookPath("bwrap")
+	if err != nil {
+		return nil, fmt.Errorf("sandbox mode bwrap needs bubblewrap installed: %w", err)
+	}
+	args := []string{
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+		"--bind", root, root,
+		"--unshare-all",
+		"--die-with-parent",
+		"--chdir", root,
+	}
+	if network {
+		args = append(args, "--share-net")
+	}
+	args = append(args, "--", "bash", "-c", script)
+	return exec.CommandContext(ctx, bwrap, args...), nil
+}
+
+// namespaceCommand re-executes sous in new user, mount and network
+// namespaces. The child remounts the file system read-only except for root
+// before it executes bash, see Init.
+func namespaceCommand(ctx context.Context, root string, network bool, script string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, self, initArg, root, "--", "bash", "-c", script)
+	flags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS)
+	if !network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: flags,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+	}
+	cmd.Dir = root
+	return cmd, nil
+}
+
+// Init sets up the sandbox if the process was started by namespaceCommand
+// and replaces it with the sandboxed command. It returns immediately in
+// every other case and must be called before anything else in main.
+func Init() {
+	if len(os.Args) < 5 || os.Args[1] != initArg || os.Args[3] != "--" {
+		return
+	}
+	root := os.Args[2]
+	if err := setupMounts(root); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+	argv := os.Args[4:]
+	bin, err := exec.LookPath(argv[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(127)
+	}
+	env := append(os.Environ(), "TMPDIR=/tmp")
+	if err := syscall.Exec(bin, argv, env); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+}
+
+func setupMounts(root string) error {
+	// never touch the mounts of the host if the namespaces were not set
+	// up, the initial user namespace maps the whole uid range
+	uidMap, err := os.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return err
+	}
+	if f := strings.Fields(string(uidMap)); len(f) == 3 && f[2] == "4294967295" {
+		return fmt.Errorf("not running in a separate namespace")
+	}
+	// keep our mounts from propagating back to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	// clone root before the tmpfs covers /tmp, the project may be below it
+	tree, err := unix.OpenTree(unix.AT_FDCWD, root, unix.OPEN_TREE_CLONE|unix.AT_RECURSIVE|unix.O_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("clone %s: %w", root, err)
+	}
+	defer unix.Close(tree)
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", 0, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+	// attach the clone as a mount of its own, so it can stay writable
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return fmt.Errorf("recreate %s: %w", root, err)
+	}
+	if err := unix.MoveMount(tree, "", unix.AT_FDCWD, root, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("bind %s: %w", root, err)
+	}
+	readOnly := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+	if err := unix.MountSetattr(-1, "/", unix.AT_RECURSIVE, readOnly); err != nil {
+		return fmt.Errorf("make / read-only: %w", err)
+	}
+	writable := &unix.MountAttr{Attr_clr: unix.MOUNT_ATTR_RDONLY}
+	for _, dir := range []string{root, "/tmp"} {
+		if err := unix.MountSetattr(-1, dir, unix.AT_RECURSIVE, writable); err != nil {
+			return fmt.Errorf("make %s writable: %w", dir, err)
+		}
+	}
+	return os.Chdir(root)
+}
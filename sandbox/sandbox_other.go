@@ -0,0 +1,21 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// Command returns a command running script with bash. Sandboxing is only
+// supported on linux.
+func Command(ctx context.Context, opts Options, script string) (*exec.Cmd, error) {
+	if opts.Enabled() {
+		return nil, fmt.Errorf("sandbox mode %q is only supported on linux", opts.Mode)
+	}
+	return exec.CommandContext(ctx, "bash", "-c", script), nil
+}
+
+// Init does nothing outside of linux.
+func Init() {}
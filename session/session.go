@@ -0,0 +1,274 @@
+package session
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Dir returns the directory the sessions of the project in root are stored
+// in.
+func Dir(root string) string {
+	return filepath.Join(root, ".sous", "sessions")
+}
+
+// entry is a single line of a session file. The first line of every file
+// has type "session", the others describe changes of the conversation.
+type entry struct {
+	Type    string          `json:"type"`
+	Time    time.Time       `json:"time"`
+	ID      string          `json:"id,omitempty"`
+	Model   string          `json:"model,omitempty"`
+	Dir     string          `json:"dir,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+	// Keep is the number of messages kept by a reset.
+	Keep int `json:"keep,omitempty"`
+}
+
+const (
+	typeSession = "session"
+	typeMessage = "message"
+	// typeReset truncates the conversation, e.g. after it was summarized or
+	// rewound.
+	typeReset = "reset"
+)
+
+// Session appends the conversation of a chat to a JSONL file. The file is
+// created with the first message, so sessions without any are not kept.
+type Session struct {
+	ID string
+	// Model is recorded with every message.
+	Model string
+
+	dir     string
+	file    *os.File
+	written int
+}
+
+// New starts a session in dir.
+func New(dir string, model string) *Session {
+	return &Session{ID: newID(), Model: model, dir: dir}
+}
+
+func newID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+func (s *Session) path() string {
+	return filepath.Join(s.dir, s.ID+".jsonl")
+}
+
+// Sync appends the messages of conversation that are not saved yet.
+func (s *Session) Sync(conversation []openai.ChatCompletionMessageParamUnion) error {
+	if s.written > len(conversation) {
+		// the conversation shrank without a Reset
+		if err := s.Reset(len(conversation)); err != nil {
+			return err
+		}
+	}
+	for _, m := range conversation[s.written:] {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if err := s.write(entry{Type: typeMessage, Model: s.Model, Message: data}); err != nil {
+			return err
+		}
+		s.written++
+	}
+	return nil
+}
+
+// Reset records that only the first keep messages of the conversation
+// remain, later messages are appended by the next Sync.
+func (s *Session) Reset(keep int) error {
+	if s.written == 0 && keep == 0 {
+		return nil
+	}
+	if err := s.write(entry{Type: typeReset, Keep: keep}); err != nil {
+		return err
+	}
+	s.written = min(s.written, keep)
+	return nil
+}
+
+func (s *Session) write(e entry) error {
+	if s.file == nil {
+		if err := s.create(); err != nil {
+			return err
+		}
+	}
+	e.Time = time.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+func (s *Session) create() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 {
+		return terminateLine(s.path(), f)
+	}
+	wd, _ := os.Getwd()
+	return s.write(entry{Type: typeSession, ID: s.ID, Model: s.Model, Dir: wd})
+}
+
+// terminateLine ends a partial last line left behind by a crash, so that
+// new entries start on a line of their own.
+func terminateLine(path string, f *os.File) error {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 || data[len(data)-1] == '\n' {
+		return err
+	}
+	_, err = f.Write([]byte{'\n'})
+	return err
+}
+
+// Close closes the session file.
+func (s *Session) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// Open loads the session id from dir and returns it ready to append to,
+// together with its conversation.
+func Open(dir string, id string, model string) (*Session, []openai.ChatCompletionMessageParamUnion, error) {
+	s := &Session{ID: id, Model: model, dir: dir}
+	conversation, _, err := s.load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("there is no session %s in %s", id, dir)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	s.written = len(conversation)
+	return s, conversation, nil
+}
+
+// load replays the session file.
+func (s *Session) load() ([]openai.ChatCompletionMessageParamUnion, []entry, error) {
+	f, err := os.Open(s.path())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	conversation := []openai.ChatCompletionMessageParamUnion{}
+	entries := []entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a crash may leave a partial line behind, which is skipped
+			continue
+		}
+		switch e.Type {
+		case typeMessage:
+			var m openai.ChatCompletionMessageParamUnion
+			if err := json.Unmarshal(e.Message, &m); err != nil {
+				return nil, nil, fmt.Errorf("%s:%d: %w", s.path(), line, err)
+			}
+			conversation = append(conversation, m)
+		case typeReset:
+			conversation = conversation[:min(e.Keep, len(conversation))]
+		}
+		entries = append(entries, e)
+	}
+	return conversation, entries, scanner.Err()
+}
+
+// Info describes a stored session.
+type Info struct {
+	ID          string
+	Created     time.Time
+	Updated     time.Time
+	Messages    int
+	FirstPrompt string
+}
+
+// List returns the sessions stored in dir, the most recently used first.
+func List(dir string) ([]Info, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	infos := []Info{}
+	for _, file := range files {
+		s := &Session{ID: strings.TrimSuffix(filepath.Base(file), ".jsonl"), dir: dir}
+		conversation, entries, err := s.load()
+		if err != nil || len(entries) == 0 {
+			continue
+		}
+		info := Info{
+			ID:       s.ID,
+			Created:  entries[0].Time,
+			Updated:  entries[len(entries)-1].Time,
+			Messages: len(conversation),
+		}
+		for _, e := range entries {
+			if prompt, ok := userPrompt(e); ok {
+				info.FirstPrompt = prompt
+				break
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Updated.After(infos[j].Updated) })
+	return infos, nil
+}
+
+// Latest returns the id of the most recently used session in dir.
+func Latest(dir string) (string, error) {
+	infos, err := List(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(infos) == 0 {
+		return "", fmt.Errorf("there are no sessions in %s", dir)
+	}
+	return infos[0].ID, nil
+}
+
+func userPrompt(e entry) (string, bool) {
+	if e.Type != typeMessage {
+		return "", false
+	}
+	var m struct {
+		Role    string `json:"role"`
+		Content any    `json:"content"`
+	}
+	if json.Unmarshal(e.Message, &m) != nil || m.Role != "user" {
+		return "", false
+	}
+	text, ok := m.Content.(string)
+	return text, ok
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moritz-tiesler/sous/session"
+	"github.com/openai/openai-go"
+)
+
+// resumeFlag is the value of -resume. Given without an id, sous lets the
+// user pick one of the stored sessions.
+type resumeFlag struct {
+	set bool
+	id  string
+}
+
+func (f *resumeFlag) String() string { return f.id }
+
+func (f *resumeFlag) Set(v string) error {
+	f.set = true
+	if v != "true" {
+		f.id = v
+	}
+	return nil
+}
+
+// IsBoolFlag allows -resume without a value, -resume <id> still works
+// because main takes the id from the remaining arguments and parses the
+// flags after it.
+func (f *resumeFlag) IsBoolFlag() bool { return true }
+
+// openSession starts a new session or resumes a stored one, depending on
+// the -continue and -resume flags.
+func openSession(
+	dir string,
+	model string,
+	cont bool,
+	resume resumeFlag,
+	getUserInput func(prompt string) (string, bool),
+) (*session.Session, []openai.ChatCompletionMessageParamUnion, error) {
+	id := resume.id
+	switch {
+	case cont:
+		latest, err := session.Latest(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		id = latest
+	case resume.set && id == "":
+		picked, err := pickSession(dir, getUserInput)
+		if err != nil {
+			return nil, nil, err
+		}
+		id = picked
+	case !resume.set:
+		return session.New(dir, model), nil, nil
+	}
+	return session.Open(dir, id, model)
+}
+
+// pickSession lists the stored sessions and asks the user to choose one.
+func pickSession(dir string, getUserInput func(prompt string) (string, bool)) (string, error) {
+	infos, err := session.List(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(infos) == 0 {
+		return "", fmt.Errorf("there are no sessions in %s", dir)
+	}
+	infos = infos[:min(len(infos), 20)]
+	for i, info := range infos {
+		prompt := strings.Join(strings.Fields(info.FirstPrompt), " ")
+		if len(prompt) > 60 {
+			prompt = prompt[:60] + "..."
+		}
+		PrintAction("%2d  %s  %3d messages  %s\n", i+1, info.Updated.Format("2006-01-02 15:04"), info.Messages, info.ID)
+		fmt.Printf("    %s\n", prompt)
+	}
+	for {
+		PrintAction("Resume which session? ")
+		answer, ok := getUserInput(fmt.Sprintf("[1-%d]: ", len(infos)))
+		if !ok {
+			return "", fmt.Errorf("no session picked")
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err == nil && n >= 1 && n <= len(infos) {
+			return infos[n-1].ID, nil
+		}
+	}
+}
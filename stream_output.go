@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// streamPrinter echoes content deltas while they arrive and remembers how
+// many terminal lines it used, so the raw text can be replaced by the
+// rendered markdown once the message is complete.
+type streamPrinter struct {
+	thinking    bool
+	tty         bool
+	width       int
+	prefixWidth int
+	col         int
+	lines       int
+}
+
+func newStreamPrinter(prefixWidth int) *streamPrinter {
+	p := &streamPrinter{width: 80, prefixWidth: prefixWidth, col: prefixWidth}
+	fd := int(os.Stdout.Fd())
+	if term.IsTerminal(fd) {
+		p.tty = true
+		if w, _, err := term.GetSize(fd); err == nil && w > 0 {
+			p.width = w
+		}
+	}
+	return p
+}
+
+func (p *streamPrinter) Write(delta string) {
+	if strings.TrimSpace(delta) == "<think>" {
+		p.thinking = true
+	}
+	if p.thinking {
+		PrintThink("%s", delta)
+	} else {
+		PrintNonThink("%s", delta)
+	}
+	if strings.TrimSpace(delta) == "</think>" {
+		p.thinking = false
+	}
+	for _, r := range delta {
+		if r == '\n' {
+			p.lines++
+			p.col = 0
+			continue
+		}
+		p.col++
+		if p.col >= p.width {
+			p.lines++
+			p.col = 0
+		}
+	}
+}
+
+// Clear erases everything written so far, including the prompt prefix.
+// Output that is not a terminal is left untouched.
+func (p *streamPrinter) Clear() {
+	if !p.tty {
+		fmt.Println()
+		return
+	}
+	if p.lines > 0 {
+		fmt.Printf("\u001b[%dF", p.lines)
+	} else {
+		fmt.Print("\r")
+	}
+	fmt.Print("\u001b[J")
+	p.lines = 0
+	p.col = 0
+}
+
+// Log prints a message of the client, e.g. about a retry, while a response
+// streams. The partial response is erased, a retried request streams it
+// again, and the message stays above the new prefix.
+func (p *streamPrinter) Log(format string, args ...any) {
+	p.Clear()
+	PrintAction(format+"\n", args...)
+	fmt.Printf(PREFIX, "")
+	p.col = p.prefixWidth
+}
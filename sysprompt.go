@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/moritz-tiesler/sous/config"
+	"github.com/moritz-tiesler/sous/prompt"
+	"github.com/moritz-tiesler/sous/tools"
+	"github.com/openai/openai-go"
+)
+
+// systemPrompt builds the system prompt for the project in root. Broken
+// instruction files are reported and left out.
+func systemPrompt(root string, registry *tools.Registry) string {
+	names := []string{}
+	for _, t := range registry.All() {
+		names = append(names, t.Name)
+	}
+	p, err := prompt.Build(prompt.Options{
+		Root:      root,
+		Tools:     names,
+		GlobalDir: filepath.Dir(config.DefaultPath()),
+	})
+	if err != nil {
+		PrintAction("loading the instructions failed: %s\n", err.Error())
+	}
+	for _, file := range p.Files {
+		PrintAction("Loaded instructions from %s\n", file)
+	}
+	return p.Text
+}
+
+// withSystemPrompt makes text the first message of conversation, replacing
+// a system prompt that is already there.
+func withSystemPrompt(
+	conversation []openai.ChatCompletionMessageParamUnion,
+	text string,
+) []openai.ChatCompletionMessageParamUnion {
+	if text == "" {
+		return conversation
+	}
+	message := openai.SystemMessage(text)
+	if len(conversation) > 0 && conversation[0].OfSystem != nil {
+		conversation[0] = message
+		return conversation
+	}
+	return append([]openai.ChatCompletionMessageParamUnion{message}, conversation...)
+}
@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileChange describes a modification of a file made by a tool.
+type FileChange struct {
+	Tool    string
+	Path    string
+	Existed bool
+	Before  []byte
+	After   []byte
+	Deleted bool
+}
+
+type callKey struct{}
+
+type callInfo struct {
+	registry *Registry
+	tool     string
+}
+
+// writeFile writes data to path and reports the change to the registry
+// the current tool call belongs to. All tools that modify files go through
+// writeFile or removeFile.
+func writeFile(ctx context.Context, path string, data []byte) error {
+	if err := checkPath(ctx, path); err != nil {
+		return err
+	}
+	before, existed, err := readExisting(path)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	notifyChange(ctx, FileChange{Path: path, Existed: existed, Before: before, After: data})
+	return nil
+}
+
+// removeFile deletes path and reports the change like writeFile.
+func removeFile(ctx context.Context, path string) error {
+	if err := checkPath(ctx, path); err != nil {
+		return err
+	}
+	before, existed, err := readExisting(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	notifyChange(ctx, FileChange{Path: path, Existed: existed, Before: before, Deleted: true})
+	return nil
+}
+
+// checkPath asks the registry of the current call whether path may be
+// modified.
+func checkPath(ctx context.Context, path string) error {
+	info, ok := ctx.Value(callKey{}).(callInfo)
+	if !ok || info.registry.CheckPath == nil {
+		return nil
+	}
+	return info.registry.CheckPath(path)
+}
+
+func readExisting(path string) ([]byte, bool, error) {
+	before, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return before, true, nil
+}
+
+// pendingKey marks a context whose file changes are held back.
+type pendingKey struct{}
+
+// pendingChanges collects the file changes of several writes that must
+// all succeed, so nothing is reported for writes that are rolled back.
+type pendingChanges struct {
+	changes []FileChange
+}
+
+// holdChanges returns a context in which writeFile and removeFile collect
+// their changes instead of reporting them.
+func holdChanges(ctx context.Context) (context.Context, *pendingChanges) {
+	pending := &pendingChanges{}
+	return context.WithValue(ctx, pendingKey{}, pending), pending
+}
+
+// report passes the collected changes on, ctx is the context given to
+// holdChanges.
+func (p *pendingChanges) report(ctx context.Context) {
+	for _, change := range p.changes {
+		notifyChange(ctx, change)
+	}
+}
+
+func notifyChange(ctx context.Context, change FileChange) {
+	if pending, ok := ctx.Value(pendingKey{}).(*pendingChanges); ok {
+		pending.changes = append(pending.changes, change)
+		return
+	}
+	info, ok := ctx.Value(callKey{}).(callInfo)
+	if !ok || info.registry.OnFileChange == nil {
+		return
+	}
+	change.Tool = info.tool
+	info.registry.OnFileChange(change)
+}
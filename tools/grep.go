@@ -0,0 +1,156 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultGrepResults = 100
+	maxGrepFileSize    = 5 << 20
+	maxGrepLineLength  = 500
+)
+
+type GrepArgs struct {
+	Pattern    string   `json:"pattern" desc:"Regular expression (RE2 syntax) to search for."`
+	Path       string   `json:"path,omitempty" desc:"File or directory to search in, defaults to the working directory."`
+	Include    []string `json:"include,omitempty" desc:"Only search files matching one of these globs, e.g. *.go or src/**/*.ts."`
+	Exclude    []string `json:"exclude,omitempty" desc:"Skip files matching one of these globs."`
+	IgnoreCase bool     `json:"ignoreCase,omitempty" desc:"Match case insensitively."`
+	Context    int      `json:"context,omitempty" desc:"Number of lines to show before and after every match."`
+	MaxResults int      `json:"maxResults,omitempty" desc:"Maximum number of matching lines to return, defaults to 100."`
+}
+
+// Grep searches files below a directory for a regular expression. Files
+// ignored by .gitignore and binary files are skipped.
+func Grep(ctx context.Context, args GrepArgs) (string, error) {
+	expr := args.Pattern
+	if args.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	root := args.Path
+	if root == "" {
+		root = "."
+	}
+	limit := args.MaxResults
+	if limit <= 0 {
+		limit = defaultGrepResults
+	}
+	contextLines := max(0, args.Context)
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+
+	out := strings.Builder{}
+	matches := 0
+	files := 0
+	truncated := false
+	search := func(p string, display string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, more := grepFile(&out, p, display, re, contextLines, limit-matches)
+		if n > 0 {
+			files++
+		}
+		matches += n
+		if more {
+			truncated = true
+			return fs.SkipAll
+		}
+		return nil
+	}
+
+	if !info.IsDir() {
+		if err := search(root, root); err != nil && err != fs.SkipAll {
+			return "", err
+		}
+	} else {
+		err = walkTree(root, 0, ignoreDirs(ctx), func(p string, rel string, d fs.DirEntry, depth int) error {
+			if d.IsDir() {
+				return nil
+			}
+			if len(args.Include) > 0 && !matchesAny(args.Include, rel) {
+				return nil
+			}
+			if matchesAny(args.Exclude, rel) {
+				return nil
+			}
+			return search(p, p)
+		})
+		if err != nil && err != fs.SkipAll {
+			return "", err
+		}
+	}
+
+	if matches == 0 {
+		return "No matches found", nil
+	}
+	if truncated {
+		fmt.Fprintf(&out, "[results truncated after %d matches, narrow the search with path, include or a more specific pattern]\n", matches)
+	} else {
+		fmt.Fprintf(&out, "[%d matches in %d files]\n", matches, files)
+	}
+	return out.String(), nil
+}
+
+// grepFile writes the matches of re in the file p to out, in the format
+// path:line:text for matches and path-line-text for context lines. It
+// returns the number of matches and whether more than limit were found.
+func grepFile(out *strings.Builder, p string, display string, re *regexp.Regexp, context int, limit int) (int, bool) {
+	info, err := os.Stat(p)
+	if err != nil || info.Size() > maxGrepFileSize {
+		return 0, false
+	}
+	data, err := os.ReadFile(p)
+	if err != nil || isBinary(data) {
+		return 0, false
+	}
+	lines := splitLines(string(data))
+	matches := 0
+	lastPrinted := -1
+	for i, line := range lines {
+		if !re.MatchString(line) {
+			continue
+		}
+		if matches == limit {
+			return matches, true
+		}
+		matches++
+		from := max(0, i-context, lastPrinted+1)
+		if lastPrinted >= 0 && from > lastPrinted+1 {
+			out.WriteString("--\n")
+		}
+		for j := from; j < i; j++ {
+			fmt.Fprintf(out, "%s-%d-%s\n", display, j+1, clip(lines[j]))
+		}
+		fmt.Fprintf(out, "%s:%d:%s\n", display, i+1, clip(line))
+		lastPrinted = i
+		// trailing context is printed up to the next match
+		for j := i + 1; j <= min(len(lines)-1, i+context); j++ {
+			if re.MatchString(lines[j]) {
+				break
+			}
+			fmt.Fprintf(out, "%s-%d-%s\n", display, j+1, clip(lines[j]))
+			lastPrinted = j
+		}
+	}
+	return matches, false
+}
+
+func clip(line string) string {
+	if len(line) <= maxGrepLineLength {
+		return line
+	}
+	return line[:maxGrepLineLength] + "..."
+}
@@ -0,0 +1,120 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListDepth   = 3
+	defaultListEntries = 300
+)
+
+type ListFilesArgs struct {
+	DirPath    string `json:"dirPath,omitempty" desc:"the path of the dir to list, defaults to the working directory"`
+	MaxDepth   int    `json:"maxDepth,omitempty" desc:"How many directory levels to descend, 1 lists only the directory itself. Defaults to 3, or no limit for a pattern containing **."`
+	Pattern    string `json:"pattern,omitempty" desc:"Only list files matching this glob, e.g. *.go or cmd/**/*.go. Patterns without ** only see files within maxDepth."`
+	MaxEntries int    `json:"maxEntries,omitempty" desc:"Maximum number of entries to return, defaults to 300."`
+}
+
+// ListFiles lists a directory tree. Directories that are not expanded
+// because of the depth limit show how many entries they contain.
+func ListFiles(ctx context.Context, args ListFilesArgs) (string, error) {
+	root := args.DirPath
+	if root == "" {
+		root = "."
+	}
+	depth := args.MaxDepth
+	switch {
+	case depth > 0:
+	case strings.Contains(args.Pattern, "**"):
+		// a pattern spanning any number of directories must not miss
+		// the deeper ones
+		depth = 0
+	default:
+		depth = defaultListDepth
+	}
+	limit := args.MaxEntries
+	if limit <= 0 {
+		limit = defaultListEntries
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", root)
+	}
+
+	out := strings.Builder{}
+	shown, files, dirs := 0, 0, 0
+	var size int64
+	err = walkTree(root, depth, ignoreDirs(ctx), func(p string, rel string, d fs.DirEntry, level int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs++
+			if args.Pattern != "" {
+				return nil
+			}
+			if shown < limit {
+				line := rel + "/"
+				if level == depth {
+					if entries, err := os.ReadDir(p); err == nil && len(entries) > 0 {
+						line += fmt.Sprintf(" (%d entries, not expanded)", len(entries))
+					}
+				}
+				out.WriteString(line + "\n")
+			}
+			shown++
+			return nil
+		}
+		if args.Pattern != "" && !matchesAny([]string{args.Pattern}, rel) {
+			return nil
+		}
+		files++
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += fi.Size()
+		if shown < limit {
+			fmt.Fprintf(&out, "%-60s %9s  %s\n", rel, formatSize(fi.Size()), fi.ModTime().Format(time.DateTime))
+		}
+		shown++
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if shown == 0 {
+		if args.Pattern != "" {
+			return fmt.Sprintf("No files matching %s in %s", args.Pattern, root), nil
+		}
+		return fmt.Sprintf("%s is empty", root), nil
+	}
+	if shown > limit {
+		fmt.Fprintf(&out, "[truncated, %d of %d entries shown. List a subdirectory, lower maxDepth or use a pattern to see the rest]\n", limit, shown)
+	}
+	fmt.Fprintf(&out, "[%d files (%s), %d directories]\n", files, formatSize(size), dirs)
+	return out.String(), nil
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
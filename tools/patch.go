@@ -0,0 +1,553 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type ApplyPatchArgs struct {
+	Patch string `json:"patch" desc:"A unified diff (as produced by git diff or diff -u) or a patch envelope starting with '*** Begin Patch' containing '*** Add File: <path>', '*** Update File: <path>' and '*** Delete File: <path>' sections."`
+}
+
+// ApplyPatch applies a patch across several files. Either every hunk
+// applies and all files are written, or nothing is changed.
+func ApplyPatch(ctx context.Context, args ApplyPatchArgs) (string, error) {
+	patches, err := parsePatch(args.Patch)
+	if err != nil {
+		return "", err
+	}
+	if len(patches) == 0 {
+		return "", fmt.Errorf("patch does not contain any file changes")
+	}
+
+	// every section is applied to the file as it is on disk, so a second
+	// section for the same file would undo the first one
+	seen := map[string]bool{}
+	for _, p := range patches {
+		if p.moveTo != "" && filepath.Clean(p.moveTo) == filepath.Clean(p.path) {
+			p.moveTo = ""
+		}
+		for _, path := range []string{p.path, p.moveTo} {
+			if path == "" {
+				continue
+			}
+			if seen[filepath.Clean(path)] {
+				return "", fmt.Errorf("patch not applied: %s appears in more than one section, combine its changes into one section", path)
+			}
+			seen[filepath.Clean(path)] = true
+		}
+	}
+
+	// check all paths up front, so a forbidden file does not leave the
+	// patch half applied
+	for _, p := range patches {
+		for _, path := range []string{p.path, p.moveTo} {
+			if path == "" {
+				continue
+			}
+			if err := checkPath(ctx, path); err != nil {
+				return "", fmt.Errorf("patch not applied: %w", err)
+			}
+		}
+	}
+
+	report := strings.Builder{}
+	results := make([]patchResult, 0, len(patches))
+	failed := false
+	for _, p := range patches {
+		res := p.apply()
+		results = append(results, res)
+		report.WriteString(res.report)
+		if res.err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return "", fmt.Errorf("patch not applied, no files were changed:\n%s", report.String())
+	}
+
+	// changes are only reported once all files are written
+	writeCtx, pending := holdChanges(ctx)
+	written := []patchResult{}
+	for _, res := range results {
+		if err := res.write(writeCtx); err != nil {
+			// a move may have written the target before failing
+			res.rollback()
+			for _, w := range written {
+				w.rollback()
+			}
+			return "", fmt.Errorf("patch not applied, writing %s failed: %w", res.path, err)
+		}
+		written = append(written, res)
+	}
+	pending.report(ctx)
+	return "Patch applied:\n" + report.String(), nil
+}
+
+type hunk struct {
+	header   string
+	oldStart int
+	// numbered is set if the header has line numbers, oldStart is 0 for
+	// an insertion at the start of the file then
+	numbered bool
+	ops      []diffOp
+}
+
+type filePatch struct {
+	op     string // "add", "update" or "delete"
+	path   string
+	moveTo string
+	hunks  []hunk
+}
+
+type patchResult struct {
+	path    string
+	moveTo  string
+	delete  bool
+	content string
+	existed bool
+	before  []byte
+	report  string
+	err     error
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+func parsePatch(patch string) ([]*filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	for _, l := range lines {
+		if strings.HasPrefix(l, "*** Begin Patch") ||
+			strings.HasPrefix(l, "*** Add File:") ||
+			strings.HasPrefix(l, "*** Update File:") ||
+			strings.HasPrefix(l, "*** Delete File:") {
+			return parseEnvelope(lines)
+		}
+	}
+	return parseUnified(lines)
+}
+
+func parseEnvelope(lines []string) ([]*filePatch, error) {
+	patches := []*filePatch{}
+	var cur *filePatch
+	var h *hunk
+	blanks := 0
+	for i, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "*** Begin Patch"), strings.HasPrefix(l, "*** End of File"):
+		case strings.HasPrefix(l, "*** End Patch"):
+			return patches, nil
+		case strings.HasPrefix(l, "*** Add File:"):
+			cur = &filePatch{op: "add", path: strings.TrimSpace(strings.TrimPrefix(l, "*** Add File:"))}
+			patches = append(patches, cur)
+			cur.hunks = []hunk{{header: "add"}}
+			h = &cur.hunks[0]
+		case strings.HasPrefix(l, "*** Update File:"):
+			cur = &filePatch{op: "update", path: strings.TrimSpace(strings.TrimPrefix(l, "*** Update File:"))}
+			patches = append(patches, cur)
+			h = nil
+		case strings.HasPrefix(l, "*** Delete File:"):
+			cur = &filePatch{op: "delete", path: strings.TrimSpace(strings.TrimPrefix(l, "*** Delete File:"))}
+			patches = append(patches, cur)
+			h = nil
+		case strings.HasPrefix(l, "*** Move to:"):
+			if cur == nil || cur.op != "update" {
+				return nil, fmt.Errorf("line %d: '*** Move to' outside of an update section", i+1)
+			}
+			cur.moveTo = strings.TrimSpace(strings.TrimPrefix(l, "*** Move to:"))
+		case cur == nil:
+			if strings.TrimSpace(l) != "" {
+				return nil, fmt.Errorf("line %d: expected a file section, got %q", i+1, l)
+			}
+		case cur.op == "delete":
+			if strings.TrimSpace(l) != "" {
+				return nil, fmt.Errorf("line %d: unexpected content after '*** Delete File'", i+1)
+			}
+		case cur.op == "add":
+			if !strings.HasPrefix(l, "+") {
+				if strings.TrimSpace(l) == "" && i == len(lines)-1 {
+					continue
+				}
+				return nil, fmt.Errorf("line %d: lines of an added file must start with '+'", i+1)
+			}
+			h.ops = append(h.ops, diffOp{'+', l[1:]})
+		case strings.HasPrefix(l, "@@"):
+			blanks = 0
+			cur.hunks = append(cur.hunks, hunk{header: l})
+			h = &cur.hunks[len(cur.hunks)-1]
+		case l == "":
+			blanks++
+		default:
+			if h == nil {
+				cur.hunks = append(cur.hunks, hunk{header: "@@"})
+				h = &cur.hunks[len(cur.hunks)-1]
+			}
+			op, ok := parseHunkLine(l)
+			if !ok {
+				return nil, fmt.Errorf("line %d: hunk lines must start with ' ', '-' or '+', got %q", i+1, l)
+			}
+			h.addBlanks(blanks)
+			h.ops = append(h.ops, op)
+		}
+		if l != "" {
+			blanks = 0
+		}
+	}
+	return patches, nil
+}
+
+func parseUnified(lines []string) ([]*filePatch, error) {
+	patches := []*filePatch{}
+	var cur *filePatch
+	var h *hunk
+	blanks := 0
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		if l != "" && (strings.HasPrefix(l, "@@") || strings.HasPrefix(l, "--- ") || strings.HasPrefix(l, "diff ")) {
+			blanks = 0
+		}
+		if strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			oldPath := patchPath(l[4:], "a/")
+			newPath := patchPath(lines[i+1][4:], "b/")
+			i++
+			cur = &filePatch{op: "update", path: oldPath}
+			switch {
+			case oldPath == "/dev/null":
+				cur.op, cur.path = "add", newPath
+			case newPath == "/dev/null":
+				cur.op = "delete"
+			case newPath != oldPath:
+				cur.moveTo = newPath
+			}
+			patches = append(patches, cur)
+			h = nil
+			continue
+		}
+		if cur == nil {
+			// git headers, commit messages and the like
+			continue
+		}
+		if strings.HasPrefix(l, "@@") {
+			hk := hunk{header: l}
+			if m := hunkHeader.FindStringSubmatch(l); m != nil {
+				hk.oldStart, _ = strconv.Atoi(m[1])
+				hk.numbered = true
+			}
+			cur.hunks = append(cur.hunks, hk)
+			h = &cur.hunks[len(cur.hunks)-1]
+			continue
+		}
+		if h == nil || strings.HasPrefix(l, `\`) {
+			continue
+		}
+		if strings.HasPrefix(l, "diff ") {
+			h = nil
+			continue
+		}
+		if l == "" {
+			blanks++
+			continue
+		}
+		op, ok := parseHunkLine(l)
+		if !ok {
+			return nil, fmt.Errorf("line %d: hunk lines must start with ' ', '-' or '+', got %q", i+1, l)
+		}
+		h.addBlanks(blanks)
+		blanks = 0
+		h.ops = append(h.ops, op)
+	}
+	return patches, nil
+}
+
+// addBlanks adds empty context lines. Models tend to drop the leading space
+// of empty context lines, so empty lines only count as context if more
+// hunk lines follow them.
+func (h *hunk) addBlanks(n int) {
+	for range n {
+		h.ops = append(h.ops, diffOp{' ', ""})
+	}
+}
+
+func patchPath(s string, prefix string) string {
+	// strip timestamps as written by diff -u
+	if tab := strings.IndexByte(s, '\t'); tab >= 0 {
+		s = s[:tab]
+	}
+	s = strings.TrimSpace(s)
+	return strings.TrimPrefix(s, prefix)
+}
+
+func parseHunkLine(l string) (diffOp, bool) {
+	switch l[0] {
+	case ' ', '-', '+':
+		return diffOp{l[0], l[1:]}, true
+	}
+	return diffOp{}, false
+}
+
+func (p *filePatch) apply() (res patchResult) {
+	res = patchResult{path: p.path, moveTo: p.moveTo}
+	report := strings.Builder{}
+	defer func() { res.report = report.String() }()
+
+	before, existed, err := readExisting(p.path)
+	if err != nil {
+		res.err = err
+		fmt.Fprintf(&report, "%s %s: FAILED, %v\n", p.op, p.path, err)
+		return res
+	}
+	res.before, res.existed = before, existed
+
+	switch p.op {
+	case "add":
+		if existed {
+			res.err = fs.ErrExist
+			fmt.Fprintf(&report, "add %s: FAILED, file already exists\n", p.path)
+			return res
+		}
+		lines := []string{}
+		for _, h := range p.hunks {
+			for _, op := range h.ops {
+				if op.kind != '-' {
+					lines = append(lines, op.line)
+				}
+			}
+		}
+		res.content = joinLines(lines, true)
+		fmt.Fprintf(&report, "add %s: ok, %d lines\n", p.path, len(lines))
+		return res
+	case "delete":
+		if !existed {
+			res.err = fs.ErrNotExist
+			fmt.Fprintf(&report, "delete %s: FAILED, file does not exist\n", p.path)
+			return res
+		}
+		res.delete = true
+		fmt.Fprintf(&report, "delete %s: ok\n", p.path)
+		return res
+	}
+
+	if !existed {
+		res.err = fs.ErrNotExist
+		fmt.Fprintf(&report, "update %s: FAILED, file does not exist\n", p.path)
+		return res
+	}
+	if p.moveTo != "" {
+		_, targetExists, err := readExisting(p.moveTo)
+		if err == nil && targetExists {
+			err = fs.ErrExist
+		}
+		if err != nil {
+			res.err = err
+			fmt.Fprintf(&report, "update %s -> %s: FAILED, %v\n", p.path, p.moveTo, err)
+			return res
+		}
+	}
+	if len(p.hunks) == 0 {
+		res.err = errors.New("no hunks")
+		fmt.Fprintf(&report, "update %s: FAILED, no hunks\n", p.path)
+		return res
+	}
+	content := string(before)
+	trailingNewline := strings.HasSuffix(content, "\n") || content == ""
+	lines := splitLines(content)
+
+	fmt.Fprintf(&report, "update %s", p.path)
+	if p.moveTo != "" {
+		fmt.Fprintf(&report, " -> %s", p.moveTo)
+	}
+	report.WriteString(":\n")
+	offset := 0
+	searchFrom := 0
+	for i, h := range p.hunks {
+		newLines, at, how, err := applyHunk(lines, h, offset, searchFrom)
+		if err != nil {
+			res.err = err
+			fmt.Fprintf(&report, "  hunk %d %s: FAILED, %v\n", i+1, h.header, err)
+			continue
+		}
+		added, removed := 0, 0
+		for _, op := range h.ops {
+			switch op.kind {
+			case '+':
+				added++
+			case '-':
+				removed++
+			}
+		}
+		note := ""
+		if h.oldStart > 0 && at+1 != h.oldStart+offset {
+			note = fmt.Sprintf(", offset %+d", at+1-(h.oldStart+offset))
+		}
+		if how != "" {
+			note += ", " + how
+		}
+		fmt.Fprintf(&report, "  hunk %d %s: applied at line %d%s\n", i+1, h.header, at+1, note)
+		lines = newLines
+		offset += added - removed
+		searchFrom = at + len(h.ops) - removed
+	}
+	res.content = joinLines(lines, trailingNewline)
+	return res
+}
+
+// matchers compare a file line with a patch line, from strict to lenient.
+var matchers = []struct {
+	name  string
+	equal func(a, b string) bool
+}{
+	{"", func(a, b string) bool { return a == b }},
+	{"ignoring trailing whitespace", func(a, b string) bool {
+		return strings.TrimRight(a, " \t") == strings.TrimRight(b, " \t")
+	}},
+	{"ignoring whitespace", func(a, b string) bool {
+		return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+	}},
+}
+
+// applyHunk locates the hunk in lines and returns the patched lines, the
+// index the hunk was applied at and how leniently it had to be matched.
+func applyHunk(lines []string, h hunk, offset int, searchFrom int) ([]string, int, string, error) {
+	old := []string{}
+	for _, op := range h.ops {
+		if op.kind != '+' {
+			old = append(old, op.line)
+		}
+	}
+
+	at := -1
+	how := ""
+	if len(old) == 0 {
+		// pure insertion, trust the line numbers, without them append
+		switch {
+		case h.oldStart > 0:
+			at = min(len(lines), h.oldStart+offset)
+		case h.numbered:
+			at = 0
+		default:
+			at = len(lines)
+		}
+	} else {
+		want := searchFrom
+		if h.oldStart > 0 {
+			want = max(0, h.oldStart-1+offset)
+		}
+		for _, m := range matchers {
+			at = findClosest(lines, old, want, searchFrom, m.equal)
+			if at < 0 {
+				// hunks may come out of order
+				at = findClosest(lines, old, want, 0, m.equal)
+			}
+			if at >= 0 {
+				how = m.name
+				break
+			}
+		}
+	}
+	if at < 0 {
+		return nil, -1, "", fmt.Errorf("context not found:\n%s", indent(old, 4, 5))
+	}
+
+	result := make([]string, 0, len(lines)+len(h.ops))
+	result = append(result, lines[:at]...)
+	cursor := at
+	for _, op := range h.ops {
+		switch op.kind {
+		case ' ':
+			// keep the file's version of context lines
+			result = append(result, lines[cursor])
+			cursor++
+		case '-':
+			cursor++
+		case '+':
+			result = append(result, op.line)
+		}
+	}
+	result = append(result, lines[cursor:]...)
+	return result, at, how, nil
+}
+
+func findClosest(lines []string, old []string, want int, from int, equal func(a, b string) bool) int {
+	best := -1
+	for start := from; start+len(old) <= len(lines); start++ {
+		match := true
+		for j := range old {
+			if !equal(lines[start+j], old[j]) {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if best < 0 || abs(start-want) < abs(best-want) {
+			best = start
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func indent(lines []string, spaces int, limit int) string {
+	sb := strings.Builder{}
+	pad := strings.Repeat(" ", spaces)
+	for i, l := range lines {
+		if i == limit {
+			fmt.Fprintf(&sb, "%s... (%d more lines)\n", pad, len(lines)-limit)
+			break
+		}
+		sb.WriteString(pad + l + "\n")
+	}
+	return sb.String()
+}
+
+func joinLines(lines []string, trailingNewline bool) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	s := strings.Join(lines, "\n")
+	if trailingNewline {
+		s += "\n"
+	}
+	return s
+}
+
+func (r patchResult) write(ctx context.Context) error {
+	if r.delete {
+		return removeFile(ctx, r.path)
+	}
+	if r.moveTo != "" {
+		if err := writeFile(ctx, r.moveTo, []byte(r.content)); err != nil {
+			return err
+		}
+		return removeFile(ctx, r.path)
+	}
+	return writeFile(ctx, r.path, []byte(r.content))
+}
+
+// rollback restores the state before write, also after a write that
+// failed halfway. It does not report changes, the file ends up as it was
+// before the patch. The target of a move did not exist before, apply
+// refuses to overwrite files.
+func (r patchResult) rollback() {
+	if r.moveTo != "" {
+		os.Remove(r.moveTo)
+	}
+	if r.existed {
+		os.WriteFile(r.path, r.before, 0644)
+	} else {
+		os.Remove(r.path)
+	}
+}
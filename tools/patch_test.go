@@ -0,0 +1,319 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  []filePatch
+	}{
+		{
+			name: "unified update",
+			patch: `diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -2,2 +2,2 @@
+ two
+-three
++THREE
+`,
+			want: []filePatch{{op: "update", path: "a.txt", hunks: []hunk{{
+				header: "@@ -2,2 +2,2 @@", oldStart: 2, numbered: true,
+				ops: []diffOp{{' ', "two"}, {'-', "three"}, {'+', "THREE"}},
+			}}}},
+		},
+		{
+			name: "unified add, delete and move",
+			patch: `--- /dev/null
++++ b/new.txt
+@@ -0,0 +1 @@
++new
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-old
+--- a/from.txt
++++ b/to.txt
+@@ -1 +1 @@
+-x
++y
+`,
+			want: []filePatch{
+				{op: "add", path: "new.txt", hunks: []hunk{{
+					header: "@@ -0,0 +1 @@", numbered: true, ops: []diffOp{{'+', "new"}},
+				}}},
+				{op: "delete", path: "old.txt", hunks: []hunk{{
+					header: "@@ -1 +0,0 @@", oldStart: 1, numbered: true, ops: []diffOp{{'-', "old"}},
+				}}},
+				{op: "update", path: "from.txt", moveTo: "to.txt", hunks: []hunk{{
+					header: "@@ -1 +1 @@", oldStart: 1, numbered: true, ops: []diffOp{{'-', "x"}, {'+', "y"}},
+				}}},
+			},
+		},
+		{
+			name: "envelope",
+			patch: `*** Begin Patch
+*** Add File: new.txt
++hello
+*** Update File: a.txt
+*** Move to: b.txt
+@@ func main
+ one
+
+-two
++2
+*** Delete File: old.txt
+*** End Patch
+`,
+			want: []filePatch{
+				{op: "add", path: "new.txt", hunks: []hunk{{header: "add", ops: []diffOp{{'+', "hello"}}}}},
+				{op: "update", path: "a.txt", moveTo: "b.txt", hunks: []hunk{{
+					header: "@@ func main",
+					ops:    []diffOp{{' ', "one"}, {' ', ""}, {'-', "two"}, {'+', "2"}},
+				}}},
+				{op: "delete", path: "old.txt"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patches, err := parsePatch(tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []filePatch{}
+			for _, p := range patches {
+				got = append(got, *p)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePatch() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePatchErrors(t *testing.T) {
+	for _, patch := range []string{
+		"*** Begin Patch\n*** Add File: a.txt\nhello\n*** End Patch\n",
+		"*** Begin Patch\n*** Move to: b.txt\n*** End Patch\n",
+		"*** Begin Patch\n*** Delete File: a.txt\n-x\n*** End Patch\n",
+		"--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n*x\n",
+	} {
+		if _, err := parsePatch(patch); err == nil {
+			t.Errorf("parsePatch(%q) succeeded, want an error", patch)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		patch string
+		// want is the content of the files afterwards, "" for a file that
+		// must not exist
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:  "insert at the start",
+			files: map[string]string{"a.txt": "one\ntwo\nthree\n"},
+			patch: "--- a/a.txt\n+++ b/a.txt\n@@ -0,0 +1 @@\n+zero\n",
+			want:  map[string]string{"a.txt": "zero\none\ntwo\nthree\n"},
+		},
+		{
+			name:  "insert at the end",
+			files: map[string]string{"a.txt": "one\ntwo\nthree\n"},
+			patch: "--- a/a.txt\n+++ b/a.txt\n@@ -3,0 +4 @@\n+four\n",
+			want:  map[string]string{"a.txt": "one\ntwo\nthree\nfour\n"},
+		},
+		{
+			name:  "insert without line numbers appends",
+			files: map[string]string{"a.txt": "one\n"},
+			patch: "*** Begin Patch\n*** Update File: a.txt\n@@\n+two\n*** End Patch\n",
+			want:  map[string]string{"a.txt": "one\ntwo\n"},
+		},
+		{
+			name:  "update with shifted line numbers",
+			files: map[string]string{"a.txt": "zero\none\ntwo\nthree\n"},
+			patch: "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n one\n-two\n+TWO\n",
+			want:  map[string]string{"a.txt": "zero\none\nTWO\nthree\n"},
+		},
+		{
+			name:  "add",
+			patch: "*** Begin Patch\n*** Add File: dir/new.txt\n+hello\n*** End Patch\n",
+			want:  map[string]string{"dir/new.txt": "hello\n"},
+		},
+		{
+			name:    "add existing file",
+			files:   map[string]string{"a.txt": "one\n"},
+			patch:   "*** Begin Patch\n*** Add File: a.txt\n+hello\n*** End Patch\n",
+			want:    map[string]string{"a.txt": "one\n"},
+			wantErr: "file already exists",
+		},
+		{
+			name:  "delete",
+			files: map[string]string{"a.txt": "one\n", "b.txt": "two\n"},
+			patch: "*** Begin Patch\n*** Delete File: a.txt\n*** End Patch\n",
+			want:  map[string]string{"a.txt": "", "b.txt": "two\n"},
+		},
+		{
+			name:  "move",
+			files: map[string]string{"a.txt": "one\ntwo\n"},
+			patch: "--- a/a.txt\n+++ b/b.txt\n@@ -1,2 +1,2 @@\n one\n-two\n+2\n",
+			want:  map[string]string{"a.txt": "", "b.txt": "one\n2\n"},
+		},
+		{
+			name:    "move onto existing file",
+			files:   map[string]string{"a.txt": "one\n", "b.txt": "keep\n"},
+			patch:   "*** Begin Patch\n*** Update File: a.txt\n*** Move to: b.txt\n@@\n-one\n+1\n*** End Patch\n",
+			want:    map[string]string{"a.txt": "one\n", "b.txt": "keep\n"},
+			wantErr: "file already exists",
+		},
+		{
+			name:  "failing hunk changes nothing",
+			files: map[string]string{"a.txt": "one\n", "b.txt": "two\n"},
+			patch: "*** Begin Patch\n*** Update File: a.txt\n@@\n-one\n+1\n" +
+				"*** Update File: b.txt\n@@\n-missing\n+x\n*** End Patch\n",
+			want:    map[string]string{"a.txt": "one\n", "b.txt": "two\n"},
+			wantErr: "context not found",
+		},
+		{
+			name:  "same file in two sections",
+			files: map[string]string{"a.txt": "one\ntwo\n"},
+			patch: "*** Begin Patch\n*** Update File: a.txt\n@@\n-one\n+1\n" +
+				"*** Update File: a.txt\n@@\n-two\n+2\n*** End Patch\n",
+			want:    map[string]string{"a.txt": "one\ntwo\n"},
+			wantErr: "more than one section",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Chdir(dir)
+			for path, content := range tt.files {
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := ApplyPatch(context.Background(), ApplyPatchArgs{Patch: tt.patch})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("ApplyPatch() failed: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("ApplyPatch() succeeded, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("ApplyPatch() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			checkFiles(t, dir, tt.want)
+		})
+	}
+}
+
+func TestApplyPatchRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// deny is the path whose write is refused
+		deny  string
+		patch string
+	}{
+		{
+			name: "second file fails",
+			deny: "b.txt",
+			patch: "*** Begin Patch\n*** Update File: a.txt\n@@\n-one\n+1\n" +
+				"*** Update File: b.txt\n@@\n-two\n+2\n*** End Patch\n",
+		},
+		{
+			name: "move fails after writing the target",
+			deny: "b.txt",
+			patch: "*** Begin Patch\n*** Update File: a.txt\n@@\n-one\n+1\n" +
+				"*** Update File: b.txt\n*** Move to: c.txt\n@@\n-two\n+2\n*** End Patch\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Chdir(dir)
+			files := map[string]string{"a.txt": "one\n", "b.txt": "two\n"}
+			for path, content := range files {
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the paths are checked once before and once while writing,
+			// the second check of deny fails
+			checks := map[string]int{}
+			reported := []string{}
+			registry := &Registry{
+				CheckPath: func(path string) error {
+					checks[path]++
+					if path == tt.deny && checks[path] > 1 {
+						return errors.New("denied")
+					}
+					return nil
+				},
+				OnFileChange: func(change FileChange) {
+					reported = append(reported, change.Path)
+				},
+			}
+			ctx := context.WithValue(context.Background(), callKey{}, callInfo{registry: registry, tool: "applyPatch"})
+			if _, err := ApplyPatch(ctx, ApplyPatchArgs{Patch: tt.patch}); err == nil {
+				t.Fatal("ApplyPatch() succeeded, want an error")
+			}
+			checkFiles(t, dir, map[string]string{"a.txt": "one\n", "b.txt": "two\n", "c.txt": ""})
+			if len(reported) > 0 {
+				t.Errorf("rolled back changes were reported: %v", reported)
+			}
+		})
+	}
+}
+
+func TestApplyPatchReportsChanges(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("a.txt", []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reported := []string{}
+	registry := &Registry{OnFileChange: func(change FileChange) {
+		reported = append(reported, change.Path)
+	}}
+	ctx := context.WithValue(context.Background(), callKey{}, callInfo{registry: registry, tool: "applyPatch"})
+	patch := "*** Begin Patch\n*** Update File: a.txt\n*** Move to: b.txt\n@@\n-one\n+1\n" +
+		"*** Add File: c.txt\n+new\n*** End Patch\n"
+	if _, err := ApplyPatch(ctx, ApplyPatchArgs{Patch: patch}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"b.txt", "a.txt", "c.txt"}
+	if !reflect.DeepEqual(reported, want) {
+		t.Errorf("reported changes = %v, want %v", reported, want)
+	}
+}
+
+func checkFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	for path, content := range want {
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if content == "" {
+			if err == nil {
+				t.Errorf("%s exists, want it removed", path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("reading %s: %v", path, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", path, data, content)
+		}
+	}
+}
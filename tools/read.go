@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	defaultReadLines  = 2000
+	maxReadBytes      = 64 << 10
+	maxReadLineLength = 2000
+)
+
+type ReadFileArgs struct {
+	FilePath string `json:"filePath" desc:"The relative path of a file in the working directory."`
+	Offset   int    `json:"offset,omitempty" desc:"Line number to start reading at, starting at 1."`
+	Limit    int    `json:"limit,omitempty" desc:"Maximum number of lines to read, defaults to 2000."`
+}
+
+// ReadFile returns a range of lines of a text file, each prefixed with its
+// line number. The output is capped, the truncation marker tells the model
+// where to continue.
+func ReadFile(ctx context.Context, args ReadFileArgs) (string, error) {
+	content, err := os.ReadFile(args.FilePath)
+	if err != nil {
+		return "", err
+	}
+	if isBinary(content) {
+		return "", fmt.Errorf("%s is a binary file (%s) and cannot be read as text", args.FilePath, formatSize(int64(len(content))))
+	}
+	if len(content) == 0 {
+		return fmt.Sprintf("[%s is empty]", args.FilePath), nil
+	}
+	lines := splitLines(string(content))
+	start := max(1, args.Offset)
+	if start > len(lines) {
+		return "", fmt.Errorf("offset %d is past the end of %s, which has %d lines", start, args.FilePath, len(lines))
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultReadLines
+	}
+	end := min(len(lines), start-1+limit)
+
+	out := strings.Builder{}
+	last := start - 1
+	for i := start - 1; i < end; i++ {
+		line := lines[i]
+		if len(line) > maxReadLineLength {
+			line = line[:maxReadLineLength] + "... [line truncated]"
+		}
+		entry := fmt.Sprintf("%6d\t%s\n", i+1, line)
+		if out.Len()+len(entry) > maxReadBytes && i > start-1 {
+			break
+		}
+		out.WriteString(entry)
+		last = i + 1
+	}
+	if last < len(lines) {
+		fmt.Fprintf(&out, "[showing lines %d-%d of %d, call readFile with offset=%d to read more]\n", start, last, len(lines), last+1)
+	}
+	return out.String(), nil
+}
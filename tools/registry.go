@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/moritz-tiesler/sous/sandbox"
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go"
+)
+
+// Tool is a single tool the model can call. The JSON schema of its
+// parameters is derived from the Go struct its arguments are decoded into.
+type Tool struct {
+	Name        string
+	Description string
+	Schema      map[string]any
+	run         func(ctx context.Context, arguments string) (string, error)
+}
+
+// Registry holds the tools available to the agent, in registration order.
+type Registry struct {
+	tools  []*Tool
+	byName map[string]*Tool
+
+	// OnFileChange is called after a tool created, modified or deleted a
+	// file.
+	OnFileChange func(FileChange)
+	// CheckPath is called before a tool writes or deletes a file. A non nil
+	// error prevents the change.
+	CheckPath func(path string) error
+	// Ignore lists directories that tools walking the file tree skip.
+	Ignore []string
+	// ShellTimeout is the default timeout of shell commands.
+	ShellTimeout time.Duration
+	// Sandbox isolates shell commands from the rest of the system.
+	Sandbox sandbox.Options
+	// Sessions holds the persistent shell sessions of the session tools.
+	Sessions *SessionPool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: map[string]*Tool{}}
+}
+
+// Register adds a tool to r. The arguments sent by the model are decoded
+// into A before fn is called. Fields of A are described by struct tags:
+//
+//	json:"name[,omitempty]"  parameter name, omitempty marks it optional
+//	desc:"..."               description shown to the model
+//	enum:"a,b,c"             allowed values
+func Register[A any](
+	r *Registry,
+	name string,
+	description string,
+	fn func(ctx context.Context, args A) (string, error),
+) {
+	if _, ok := r.byName[name]; ok {
+		panic(fmt.Sprintf("tool %s registered twice", name))
+	}
+	var zero A
+	t := &Tool{
+		Name:        name,
+		Description: description,
+		Schema:      schemaOf(zero),
+	}
+	t.run = func(ctx context.Context, arguments string) (string, error) {
+		if strings.TrimSpace(arguments) == "" {
+			arguments = "{}"
+		}
+		raw := map[string]any{}
+		if err := json.Unmarshal([]byte(arguments), &raw); err != nil {
+			return "", &ArgumentError{
+				Tool:     name,
+				Problems: []string{fmt.Sprintf("arguments are not a valid JSON object: %v", err)},
+				Schema:   t.Schema,
+			}
+		}
+		if problems := validate(t.Schema, raw); len(problems) > 0 {
+			return "", &ArgumentError{Tool: name, Problems: problems, Schema: t.Schema}
+		}
+		var args A
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", &ArgumentError{Tool: name, Problems: []string{err.Error()}, Schema: t.Schema}
+		}
+		return fn(ctx, args)
+	}
+	r.tools = append(r.tools, t)
+	r.byName[name] = t
+}
+
+// Get returns the tool called name.
+func (r *Registry) Get(name string) (*Tool, bool) {
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+// All returns the registered tools in registration order.
+func (r *Registry) All() []*Tool {
+	return r.tools
+}
+
+// Call runs the tool name with the JSON encoded arguments.
+func (r *Registry) Call(ctx context.Context, name string, arguments string) (string, error) {
+	t, ok := r.byName[name]
+	if !ok {
+		return "", fmt.Errorf("tool '%s' not found", name)
+	}
+	ctx = context.WithValue(ctx, callKey{}, callInfo{registry: r, tool: name})
+	return t.call(ctx, arguments)
+}
+
+// call runs the tool and turns a panic in the handler into an error, so a
+// broken tool does not take down the session.
+func (t *Tool) call(ctx context.Context, arguments string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = ""
+			err = fmt.Errorf("tool %s failed unexpectedly: %v", t.Name, r)
+		}
+	}()
+	return t.run(ctx, arguments)
+}
+
+// OpenAI returns the tool definitions for the OpenAI chat API.
+func (r *Registry) OpenAI() []openai.ChatCompletionToolParam {
+	defs := make([]openai.ChatCompletionToolParam, 0, len(r.tools))
+	for _, t := range r.tools {
+		defs = append(defs, openai.ChatCompletionToolParam{
+			Type: "function",
+			Function: openai.FunctionDefinitionParam{
+				Name:        t.Name,
+				Description: openai.String(t.Description),
+				Parameters:  t.Schema,
+			},
+		})
+	}
+	return defs
+}
+
+// Ollama returns the tool definitions for the native ollama chat API.
+func (r *Registry) Ollama() api.Tools {
+	defs := make(api.Tools, 0, len(r.tools))
+	for _, t := range r.tools {
+		fn := api.ToolFunction{
+			Name:        t.Name,
+			Description: t.Description,
+		}
+		// the ollama parameter type mirrors the schema, so a JSON round
+		// trip is enough to convert it
+		b, err := json.Marshal(t.Schema)
+		if err != nil {
+			panic(err)
+		}
+		if err := json.Unmarshal(b, &fn.Parameters); err != nil {
+			panic(err)
+		}
+		defs = append(defs, api.Tool{Type: "function", Function: fn})
+	}
+	return defs
+}
@@ -0,0 +1,87 @@
+package tools
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// schemaOf derives the JSON schema of a tool argument struct.
+func schemaOf(v any) map[string]any {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("tool arguments must be a struct, got %v", t))
+	}
+	return typeSchema(t)
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, optional := jsonName(f)
+			if name == "-" {
+				continue
+			}
+			prop := typeSchema(f.Type)
+			prop["description"] = f.Tag.Get("desc")
+			if enum := f.Tag.Get("enum"); enum != "" {
+				values := []any{}
+				for _, e := range strings.Split(enum, ",") {
+					values = append(values, e)
+				}
+				prop["enum"] = values
+			}
+			properties[name] = prop
+			if !optional {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{
+			"type":       "object",
+			"properties": properties,
+			"required":   required,
+		}
+	}
+	panic(fmt.Sprintf("unsupported tool argument type %v", t))
+}
+
+// jsonName returns the JSON name of a struct field and whether it is
+// optional. Pointer fields and fields tagged omitempty are optional.
+func jsonName(f reflect.StructField) (string, bool) {
+	name := f.Name
+	optional := f.Type.Kind() == reflect.Pointer
+	tag := f.Tag.Get("json")
+	if tag != "" {
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			name = parts[0]
+		}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				optional = true
+			}
+		}
+	}
+	return name, optional
+}
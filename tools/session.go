@@ -0,0 +1,374 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moritz-tiesler/sous/sandbox"
+)
+
+const (
+	// maxSessionBuffer is the amount of unread output a session keeps,
+	// older output is dropped.
+	maxSessionBuffer = 64 << 10
+	// sessionQuiet is how long output has to pause before a read returns.
+	sessionQuiet     = 300 * time.Millisecond
+	defaultSendWait  = 2 * time.Second
+	maxSessionWait   = 5 * time.Minute
+	sessionKillDelay = 2 * time.Second
+)
+
+// SessionPool manages persistent bash processes that keep their working
+// directory, environment and background jobs between tool calls.
+type SessionPool struct {
+	mu       sync.Mutex
+	sessions map[int]*session
+	nextID   int
+}
+
+func NewSessionPool() *SessionPool {
+	return &SessionPool{sessions: map[int]*session{}, nextID: 1}
+}
+
+type session struct {
+	id      int
+	command string
+	started time.Time
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	unread   []byte
+	dropped  int
+	changed  chan struct{} // closed and replaced on every write
+	done     chan struct{} // closed when the process exited
+	exitCode int
+}
+
+func (s *session) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unread = append(s.unread, p...)
+	if over := len(s.unread) - maxSessionBuffer; over > 0 {
+		s.dropped += over
+		s.unread = append(s.unread[:0], s.unread[over:]...)
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// take returns the unread output and marks it as read.
+func (s *session) take() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := string(s.unread)
+	if s.dropped > 0 {
+		out = fmt.Sprintf("[... %d bytes of output omitted ...]\n%s", s.dropped, out)
+	}
+	s.unread = nil
+	s.dropped = 0
+	return out
+}
+
+func (s *session) pending() (int, chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.unread) + s.dropped, s.changed
+}
+
+func (s *session) exited() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *session) status() string {
+	if s.exited() {
+		return fmt.Sprintf("exited with code %d", s.exitCode)
+	}
+	return "running"
+}
+
+// wait blocks until output arrived and then paused for a moment, the
+// process exited, the timeout passed or ctx is done.
+func (s *session) wait(ctx context.Context, timeout time.Duration) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		n, changed := s.pending()
+		var quiet <-chan time.Time
+		if n > 0 {
+			quiet = time.After(sessionQuiet)
+		}
+		select {
+		case <-changed:
+		case <-quiet:
+			return
+		case <-s.done:
+			return
+		case <-deadline.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// start launches a new bash process, in the sandbox if one is configured.
+func (p *SessionPool) start(opts sandbox.Options) (*session, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// bash reads the commands sent to the session from stdin
+	cmd, err := sandbox.Command(ctx, opts, "exec bash --noprofile --norc -s")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	setProcessGroup(cmd)
+	cmd.WaitDelay = sessionKillDelay
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s := &session{
+		started: time.Now(),
+		cmd:     cmd,
+		stdin:   stdin,
+		cancel:  cancel,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	cmd.Stdout = s
+	cmd.Stderr = s
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		err := cmd.Wait()
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			s.exitCode = exitErr.ExitCode()
+		case err != nil:
+			s.exitCode = -1
+		}
+		close(s.done)
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.id = p.nextID
+	p.nextID++
+	p.sessions[s.id] = s
+	return s, nil
+}
+
+func (p *SessionPool) get(id int) (*session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("there is no shell session %d, use %s to see the running sessions", id, SESSION_LIST)
+	}
+	return s, nil
+}
+
+func (p *SessionPool) list() []*session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := make([]*session, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].id < all[j].id })
+	return all
+}
+
+// kill stops the session with all processes it started and removes it from
+// the pool.
+func (p *SessionPool) kill(id int) (*session, error) {
+	s, err := p.get(id)
+	if err != nil {
+		return nil, err
+	}
+	s.stdin.Close()
+	s.cancel()
+	<-s.done
+	p.mu.Lock()
+	delete(p.sessions, id)
+	p.mu.Unlock()
+	return s, nil
+}
+
+// Close kills all sessions.
+func (p *SessionPool) Close() {
+	for _, s := range p.list() {
+		p.kill(s.id)
+	}
+}
+
+// sessionPool returns the session pool of the registry the current tool
+// call belongs to.
+func sessionPool(ctx context.Context) (*SessionPool, error) {
+	info, ok := ctx.Value(callKey{}).(callInfo)
+	if !ok || info.registry.Sessions == nil {
+		return nil, errors.New("shell sessions are not available")
+	}
+	return info.registry.Sessions, nil
+}
+
+func waitDuration(seconds int, fallback time.Duration) time.Duration {
+	if seconds <= 0 {
+		return fallback
+	}
+	return min(time.Duration(seconds)*time.Second, maxSessionWait)
+}
+
+// sessionOutput formats the new output of s followed by its status.
+func sessionOutput(s *session) string {
+	out := s.take()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	if out == "" {
+		out = "(no new output)\n"
+	}
+	return out + fmt.Sprintf("[session %d %s]", s.id, s.status())
+}
+
+type SessionStartArgs struct {
+	Command string `json:"command,omitempty" desc:"Command to run right away, e.g. a dev server. The session stays open after it returns."`
+	Wait    int    `json:"wait,omitempty" desc:"Seconds to wait for the first output of command, defaults to 2."`
+}
+
+// SessionStart starts a persistent shell session.
+func SessionStart(ctx context.Context, args SessionStartArgs) (string, error) {
+	pool, err := sessionPool(ctx)
+	if err != nil {
+		return "", err
+	}
+	info := ctx.Value(callKey{}).(callInfo)
+	s, err := pool.start(info.registry.Sandbox)
+	if err != nil {
+		return "", err
+	}
+	if args.Command == "" {
+		return fmt.Sprintf("Started shell session %d", s.id), nil
+	}
+	s.command = args.Command
+	if _, err := io.WriteString(s.stdin, args.Command+"\n"); err != nil {
+		return "", fmt.Errorf("session %d: %w", s.id, err)
+	}
+	s.wait(ctx, waitDuration(args.Wait, defaultSendWait))
+	return fmt.Sprintf("Started shell session %d\n%s", s.id, sessionOutput(s)), nil
+}
+
+type SessionSendArgs struct {
+	ID    int    `json:"id" desc:"The id of the session."`
+	Input string `json:"input" desc:"Text to send to the session, usually a command. A newline is appended if missing."`
+	Wait  int    `json:"wait,omitempty" desc:"Seconds to wait for output, defaults to 2. Raise it for slow commands."`
+}
+
+// SessionSend writes input to a session and returns the output it
+// produced.
+func SessionSend(ctx context.Context, args SessionSendArgs) (string, error) {
+	pool, err := sessionPool(ctx)
+	if err != nil {
+		return "", err
+	}
+	s, err := pool.get(args.ID)
+	if err != nil {
+		return "", err
+	}
+	if s.exited() {
+		return "", fmt.Errorf("session %d %s, start a new one", s.id, s.status())
+	}
+	input := args.Input
+	if !strings.HasSuffix(input, "\n") {
+		input += "\n"
+	}
+	if _, err := io.WriteString(s.stdin, input); err != nil {
+		return "", fmt.Errorf("session %d: %w", s.id, err)
+	}
+	if s.command == "" {
+		s.command = strings.TrimSpace(args.Input)
+	}
+	s.wait(ctx, waitDuration(args.Wait, defaultSendWait))
+	return sessionOutput(s), nil
+}
+
+type SessionReadArgs struct {
+	ID   int `json:"id" desc:"The id of the session."`
+	Wait int `json:"wait,omitempty" desc:"Seconds to wait for new output if there is none yet."`
+}
+
+// SessionRead returns the output a session produced since the last read.
+func SessionRead(ctx context.Context, args SessionReadArgs) (string, error) {
+	pool, err := sessionPool(ctx)
+	if err != nil {
+		return "", err
+	}
+	s, err := pool.get(args.ID)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := s.pending(); n == 0 && args.Wait > 0 {
+		s.wait(ctx, waitDuration(args.Wait, 0))
+	}
+	return sessionOutput(s), nil
+}
+
+type SessionListArgs struct{}
+
+// SessionList lists the shell sessions.
+func SessionList(ctx context.Context, args SessionListArgs) (string, error) {
+	pool, err := sessionPool(ctx)
+	if err != nil {
+		return "", err
+	}
+	sessions := pool.list()
+	if len(sessions) == 0 {
+		return "No shell sessions", nil
+	}
+	out := strings.Builder{}
+	for _, s := range sessions {
+		n, _ := s.pending()
+		fmt.Fprintf(&out, "%d\t%s\tup %s\t%d bytes unread\t%s\n",
+			s.id, s.status(), time.Since(s.started).Round(time.Second), n, s.command)
+	}
+	return out.String(), nil
+}
+
+type SessionKillArgs struct {
+	ID int `json:"id" desc:"The id of the session."`
+}
+
+// SessionKill stops a session and every process started in it.
+func SessionKill(ctx context.Context, args SessionKillArgs) (string, error) {
+	pool, err := sessionPool(ctx)
+	if err != nil {
+		return "", err
+	}
+	s, err := pool.kill(args.ID)
+	if err != nil {
+		return "", err
+	}
+	out := s.take()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return fmt.Sprintf("%sKilled shell session %d", out, s.id), nil
+}
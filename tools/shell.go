@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moritz-tiesler/sous/sandbox"
+)
+
+const (
+	defaultShellTimeout = 2 * time.Minute
+	shellHeadBytes      = 10 << 10
+	shellTailBytes      = 20 << 10
+)
+
+type ShellArgs struct {
+	Command string `json:"command" desc:"the shell command you want to execute"`
+	Timeout int    `json:"timeout,omitempty" desc:"Timeout in seconds, defaults to the configured shell timeout. Raise it for long builds or test runs."`
+}
+
+// Shell runs a command with bash. The command and all processes it starts
+// are killed when the call is cancelled or times out.
+func Shell(ctx context.Context, args ShellArgs) (string, error) {
+	timeout := shellTimeout(ctx)
+	if args.Timeout > 0 {
+		timeout = time.Duration(args.Timeout) * time.Second
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd, err := sandbox.Command(runCtx, shellSandbox(ctx), args.Command)
+	if err != nil {
+		return "", err
+	}
+	setProcessGroup(cmd)
+	// give processes that inherited the output pipes a moment after the
+	// kill before giving up on them
+	cmd.WaitDelay = 2 * time.Second
+	out := &headTailBuffer{head: shellHeadBytes, tail: shellTailBytes}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	start := time.Now()
+	err = cmd.Run()
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	result := out.String()
+	if result != "" && !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		result += fmt.Sprintf("[killed after the timeout of %s]", timeout)
+	case ctx.Err() != nil:
+		result += fmt.Sprintf("[cancelled by the user after %s]", elapsed)
+	case errors.As(err, &exitErr):
+		result += fmt.Sprintf("[exit code %d, %s]", exitErr.ExitCode(), elapsed)
+	case err != nil:
+		return result, err
+	default:
+		result += fmt.Sprintf("[exit code 0, %s]", elapsed)
+	}
+	return result, nil
+}
+
+func shellTimeout(ctx context.Context) time.Duration {
+	info, ok := ctx.Value(callKey{}).(callInfo)
+	if !ok || info.registry.ShellTimeout <= 0 {
+		return defaultShellTimeout
+	}
+	return info.registry.ShellTimeout
+}
+
+func shellSandbox(ctx context.Context) sandbox.Options {
+	info, ok := ctx.Value(callKey{}).(callInfo)
+	if !ok {
+		return sandbox.Options{}
+	}
+	return info.registry.Sandbox
+}
+
+// headTailBuffer keeps the first head and the last tail bytes written to
+// it and counts the bytes dropped in between.
+type headTailBuffer struct {
+	mu      sync.Mutex
+	head    int
+	tail    int
+	start   []byte
+	end     []byte
+	dropped int
+}
+
+func (b *headTailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	if room := b.head - len(b.start); room > 0 {
+		take := min(room, len(p))
+		b.start = append(b.start, p[:take]...)
+		p = p[take:]
+	}
+	b.end = append(b.end, p...)
+	if over := len(b.end) - b.tail; over > 0 {
+		b.dropped += over
+		b.end = append(b.end[:0], b.end[over:]...)
+	}
+	return n, nil
+}
+
+func (b *headTailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dropped == 0 {
+		return string(b.start) + string(b.end)
+	}
+	return fmt.Sprintf("%s\n[... %d bytes of output omitted ...]\n%s", b.start, b.dropped, b.end)
+}
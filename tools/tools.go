@@ -1,289 +1,157 @@
 package tools
 
 import (
+	"context"
+	"fmt"
 	"os"
-	"os/exec"
 	"strings"
+)
 
-	"github.com/ollama/ollama/api"
+const (
+	READ_FILE   = "readFile"
+	SHELL       = "shell"
+	WRITE_FILE  = "writeFile"
+	SEARCH_FILE = "searchFile"
+	LIST_FILES  = "listFiles"
+	CREATE_FILE = "createFile"
+	EDIT_FILE   = "editFile"
+	APPLY_PATCH = "applyPatch"
+	GREP        = "grep"
+
+	SESSION_START = "sessionStart"
+	SESSION_SEND  = "sessionSend"
+	SESSION_READ  = "sessionRead"
+	SESSION_LIST  = "sessionList"
+	SESSION_KILL  = "sessionKill"
 )
 
-func ReadFile(args api.ToolCallFunctionArguments) (string, error) {
-	path := args["filePath"].(string)
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-	return string(content), nil
+// Default returns a registry with all built-in tools.
+func Default() *Registry {
+	r := NewRegistry()
+	Register(r, READ_FILE,
+		"Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names. Lines are prefixed with their line number followed by a tab, the prefix is not part of the file. Large files are returned in parts, use offset and limit to read further.",
+		ReadFile)
+	Register(r, SHELL,
+		"use the shell to execute common linux commands for file manipulation and analysis. Commands run non-interactively without stdin and are killed after a timeout. Long output is shortened to its beginning and end. If the shell is sandboxed, only the working directory and /tmp are writable and there is no network access.",
+		Shell)
+	Register(r, WRITE_FILE,
+		"write the contents to a file at a given path. Provides full control over file content. Overwrite existing content. Use with caution.",
+		WriteFile)
+	Register(r, SEARCH_FILE,
+		"Search for a string in a file and return matching lines.",
+		SearchFile)
+	Register(r, LIST_FILES,
+		"List the files and directories below a directory recursively, with sizes and modification times. Respects .gitignore. Use pattern to only list matching files and maxDepth to control how deep the listing goes.",
+		ListFiles)
+	Register(r, CREATE_FILE,
+		"Create a new file with given content",
+		CreateFile)
+	Register(r, EDIT_FILE,
+		"Replace an exact snippet of a file with new text. Prefer this over writeFile for changes to existing files. oldString must match the file content exactly, including whitespace and indentation, and must be unique unless replaceAll is set. Read the file first.",
+		EditFile)
+	Register(r, APPLY_PATCH,
+		"Apply a patch that changes, adds or deletes several files at once. Every hunk must apply, otherwise no file is changed and the result lists which hunks failed. Include a few lines of unchanged context around every change.",
+		ApplyPatch)
+	Register(r, GREP,
+		"Search the files below a directory for a regular expression and return matching lines with file names and line numbers. Respects .gitignore. Use include and exclude globs to narrow the search.",
+		Grep)
+	Register(r, SESSION_START,
+		"Start a persistent shell session for long running processes like dev servers or watchers. Unlike shell, the working directory, exported variables and background jobs survive between calls. Returns the session id.",
+		SessionStart)
+	Register(r, SESSION_SEND,
+		"Send input to a shell session, e.g. a command, and return the output it produced within the wait time.",
+		SessionSend)
+	Register(r, SESSION_READ,
+		"Read the output a shell session produced since the last read.",
+		SessionRead)
+	Register(r, SESSION_LIST,
+		"List the shell sessions with their status and the amount of unread output.",
+		SessionList)
+	Register(r, SESSION_KILL,
+		"Stop a shell session and all processes running in it.",
+		SessionKill)
+	return r
 }
 
-func Shell(args api.ToolCallFunctionArguments) (string, error) {
-	cmdString := args["command"].(string)
-
-	cmd := exec.Command("bash", "-c", cmdString)
-	res, err := cmd.CombinedOutput()
-	return string(res), err
+type WriteFileArgs struct {
+	FilePath string `json:"filePath" desc:"The relative path of the file in the working directory."`
+	Content  string `json:"content" desc:"The content to write to the file. All previous content in the file be truncated."`
 }
 
-func WriteFile(args api.ToolCallFunctionArguments) (string, error) {
-	path := args["filePath"].(string)
-	content := args["content"].(string)
-
-	err := os.WriteFile(path, []byte(content), 0644)
+func WriteFile(ctx context.Context, args WriteFileArgs) (string, error) {
+	err := writeFile(ctx, args.FilePath, []byte(args.Content))
 	if err != nil {
 		return "", err
 	}
 	return "File edited successfully", nil
 }
 
-func SearchFile(args api.ToolCallFunctionArguments) (string, error) {
-	path := args["filePath"].(string)
-	query := args["query"].(string)
-	content, err := os.ReadFile(path)
+type SearchFileArgs struct {
+	FilePath string `json:"filePath" desc:"The relative path of the file in the working directory."`
+	Query    string `json:"query" desc:"the string to look for"`
+}
+
+func SearchFile(ctx context.Context, args SearchFileArgs) (string, error) {
+	content, err := os.ReadFile(args.FilePath)
 	if err != nil {
 		return "", err
 	}
 	lines := strings.Split(string(content), "\n")
 	var matches []string
 	for _, line := range lines {
-		if strings.Contains(line, query) {
+		if strings.Contains(line, args.Query) {
 			matches = append(matches, line)
 		}
 	}
 	return strings.Join(matches, "\n"), nil
 }
 
-func ListFiles(args api.ToolCallFunctionArguments) (string, error) {
-	dirPath := args["dirPath"].(string)
-	files, err := os.ReadDir(dirPath)
-	if err != nil {
-		return "", err
-	}
-	var result []string
-	for _, file := range files {
-		fName := file.Name()
-		if file.IsDir() {
-			fName += "/"
-		}
-		result = append(result, fName)
-	}
-	return strings.Join(result, "\n"), nil
+type CreateFileArgs struct {
+	FilePath string `json:"filePath" desc:"the path of the new file"`
+	Content  string `json:"content" desc:"the content of the new file"`
 }
 
-func CreateFile(args api.ToolCallFunctionArguments) (string, error) {
-	path := args["filePath"].(string)
-	content := args["content"].(string)
-	err := os.WriteFile(path, []byte(content), 0644)
+func CreateFile(ctx context.Context, args CreateFileArgs) (string, error) {
+	err := writeFile(ctx, args.FilePath, []byte(args.Content))
 	if err != nil {
 		return "", err
 	}
 	return "File created successfully", nil
 }
 
-func ToolMap() map[string]func(api.ToolCallFunctionArguments) (string, error) {
-	return map[string]func(api.ToolCallFunctionArguments) (string, error){
-		READ_FILE:   ReadFile,
-		SHELL:       Shell,
-		WRITE_FILE:  WriteFile,
-		SEARCH_FILE: SearchFile,
-		LIST_FILES:  ListFiles,
-		CREATE_FILE: CreateFile,
-	}
+type EditFileArgs struct {
+	FilePath   string `json:"filePath" desc:"The relative path of the file in the working directory."`
+	OldString  string `json:"oldString" desc:"The exact text to replace."`
+	NewString  string `json:"newString" desc:"The text to replace oldString with."`
+	ReplaceAll bool   `json:"replaceAll,omitempty" desc:"Replace every occurrence of oldString instead of requiring it to be unique."`
 }
 
-const (
-	READ_FILE   = "readFile"
-	SHELL       = "shell"
-	WRITE_FILE  = "writeFile"
-	SEARCH_FILE = "searchFile"
-	LIST_FILES  = "listFiles"
-	CREATE_FILE = "createFile"
-)
-
-func Tools() api.Tools {
-	return api.Tools{
-		api.Tool{
-			Type: "function",
-			Function: api.ToolFunction{
-				Name:        READ_FILE,
-				Description: "Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names.",
-				Parameters: ToolFunctionParameters{
-					Type:     "object",
-					Required: []string{"filePath"},
-					Properties: ToolFunctionProperties{
-						"filePath": {
-							Type:        api.PropertyType{"string"},
-							Description: "The relative path of a file in the working directory.",
-						},
-					},
-				}.ToAPI(),
-			},
-		},
-		api.Tool{
-			Type: "function",
-			Function: api.ToolFunction{
-				Name:        SHELL,
-				Description: "use the shell to execute common linux commands for file manipulation and analysis",
-				Parameters: ToolFunctionParameters{
-					Type:     "object",
-					Required: []string{"command"},
-					Properties: ToolFunctionProperties{
-						"command": {
-							Type:        api.PropertyType{"string"},
-							Description: "the shell command you want to execute",
-						},
-					},
-				}.ToAPI(),
-			},
-		},
-		api.Tool{
-			Type: "function",
-			Function: api.ToolFunction{
-				Name:        WRITE_FILE,
-				Description: "write the contents to a file at a given path. Provides full control over file content. Overwrite existing content. Use with caution.",
-				Parameters: ToolFunctionParameters{
-					Type:     "object",
-					Required: []string{"filePath", "content"},
-					Properties: ToolFunctionProperties{
-						"filePath": {
-							Type:        api.PropertyType{"string"},
-							Description: "The relative path of the file in the working directory.",
-						},
-						"content": {
-							Type:        api.PropertyType{"string"},
-							Description: "The content to write to the file. All previous content in the file be truncated.",
-						},
-					},
-				}.ToAPI(),
-			},
-		},
-		api.Tool{
-			Type: "function",
-			Function: api.ToolFunction{
-				Name:        SEARCH_FILE,
-				Description: "Search for a string in a file and return matching lines.",
-				Parameters: ToolFunctionParameters{
-					Type:     "object",
-					Required: []string{"filePath", "query"},
-					Properties: ToolFunctionProperties{
-						"filePath": {
-							Type:        api.PropertyType{"string"},
-							Description: "The relative path of the file in the working directory.",
-						},
-						"query": {
-							Type:        api.PropertyType{"string"},
-							Description: "the string to look for",
-						},
-					},
-				}.ToAPI(),
-			},
-		},
-		api.Tool{
-			Type: "function",
-			Function: api.ToolFunction{
-				Name:        LIST_FILES,
-				Description: "List all files in a directory (or subdirectories, if needed).",
-				Parameters: ToolFunctionParameters{
-					Type:     "object",
-					Required: []string{"dirPath"},
-					Properties: ToolFunctionProperties{
-						"dirPath": {
-							Type:        api.PropertyType{"string"},
-							Description: "the path of the dir to list",
-						},
-					},
-				}.ToAPI(),
-			},
-		},
-		api.Tool{
-			Type: "function",
-			Function: api.ToolFunction{
-				Name:        CREATE_FILE,
-				Description: "Create a new file with given content",
-				Parameters: ToolFunctionParameters{
-					Type:     "object",
-					Required: []string{"filePath", "content"},
-					Properties: ToolFunctionProperties{
-						"filePath": {
-							Type:        api.PropertyType{"string"},
-							Description: "the path of the new file",
-						},
-						"content": {
-							Type:        api.PropertyType{"string"},
-							Description: "the content of the new file",
-						},
-					},
-				}.ToAPI(),
-			},
-		},
+func EditFile(ctx context.Context, args EditFileArgs) (string, error) {
+	if args.OldString == "" {
+		return "", fmt.Errorf("oldString must not be empty, use createFile to create new files")
 	}
-}
-
-type ToolFunctionParameters struct {
-	Type       string                 `json:"type"`
-	Defs       any                    `json:"$defs,omitempty"`
-	Items      any                    `json:"items,omitempty"`
-	Required   []string               `json:"required"`
-	Properties ToolFunctionProperties `json:"properties"`
-}
-
-func (t ToolFunctionParameters) ToAPI() struct {
-	Type       string   `json:"type"`
-	Defs       any      `json:"$defs,omitempty"`
-	Items      any      `json:"items,omitempty"`
-	Required   []string `json:"required"`
-	Properties map[string]struct {
-		Type        api.PropertyType `json:"type"`
-		Items       any              `json:"items,omitempty"`
-		Description string           `json:"description"`
-		Enum        []any            `json:"enum,omitempty"`
-	} `json:"properties"`
-} {
-	return struct {
-		Type       string   `json:"type"`
-		Defs       any      `json:"$defs,omitempty"`
-		Items      any      `json:"items,omitempty"`
-		Required   []string `json:"required"`
-		Properties map[string]struct {
-			Type        api.PropertyType `json:"type"`
-			Items       any              `json:"items,omitempty"`
-			Description string           `json:"description"`
-			Enum        []any            `json:"enum,omitempty"`
-		} `json:"properties"`
-	}{
-		Type:       t.Type,
-		Defs:       t.Defs,
-		Items:      t.Items,
-		Required:   t.Required,
-		Properties: t.Properties.ToAPI(),
+	if args.OldString == args.NewString {
+		return "", fmt.Errorf("oldString and newString are identical, nothing to change")
 	}
-}
-
-type ToolFunctionProperties map[string]ToolFunctionProperty
-
-type ToolFunctionProperty struct {
-	Type        api.PropertyType `json:"type"`
-	Items       any              `json:"items,omitempty"`
-	Description string           `json:"description"`
-	Enum        []any            `json:"enum,omitempty"`
-}
-
-func (t ToolFunctionProperties) ToAPI() map[string]struct {
-	Type        api.PropertyType `json:"type"`
-	Items       any              `json:"items,omitempty"`
-	Description string           `json:"description"`
-	Enum        []any            `json:"enum,omitempty"`
-} {
-	result := map[string]struct {
-		Type        api.PropertyType `json:"type"`
-		Items       any              `json:"items,omitempty"`
-		Description string           `json:"description"`
-		Enum        []any            `json:"enum,omitempty"`
-	}{}
-
-	for key, val := range t {
-		result[key] = val
+	content, err := os.ReadFile(args.FilePath)
+	if err != nil {
+		return "", err
 	}
-
-	return result
+	before := string(content)
+	count := strings.Count(before, args.OldString)
+	switch {
+	case count == 0:
+		return "", fmt.Errorf("oldString not found in %s, it must match the file content exactly including whitespace and indentation", args.FilePath)
+	case count > 1 && !args.ReplaceAll:
+		return "", fmt.Errorf("oldString occurs %d times in %s, add surrounding lines to make it unique or set replaceAll", count, args.FilePath)
+	}
+	replaced := 1
+	if args.ReplaceAll {
+		replaced = count
+	}
+	after := strings.Replace(before, args.OldString, args.NewString, replaced)
+	if err := writeFile(ctx, args.FilePath, []byte(after)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Replaced %d occurrence(s) in %s", replaced, args.FilePath), nil
 }
@@ -0,0 +1,153 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ArgumentError is returned when the arguments of a tool call do not match
+// the schema of the tool. Its message is meant for the model, so it can fix
+// the call and try again.
+type ArgumentError struct {
+	Tool     string
+	Problems []string
+	Schema   map[string]any
+}
+
+func (e *ArgumentError) Error() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "invalid arguments for tool %s:\n", e.Tool)
+	for _, p := range e.Problems {
+		fmt.Fprintf(&sb, "- %s\n", p)
+	}
+	sb.WriteString("expected parameters:\n")
+	sb.WriteString(describeParams(e.Schema))
+	return sb.String()
+}
+
+// validate checks decoded JSON arguments against a tool schema and returns
+// a list of problems, empty if the arguments are valid.
+func validate(schema map[string]any, args map[string]any) []string {
+	problems := []string{}
+	properties, _ := schema["properties"].(map[string]any)
+	required, _ := schema["required"].([]string)
+	for _, name := range required {
+		if v, ok := args[name]; !ok || v == nil {
+			prop, _ := properties[name].(map[string]any)
+			problems = append(problems, fmt.Sprintf("missing required parameter %q of type %v", name, prop["type"]))
+		}
+	}
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop, ok := properties[name].(map[string]any)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown parameter %q", name))
+			continue
+		}
+		if args[name] == nil {
+			continue
+		}
+		problems = append(problems, checkValue(name, prop, args[name])...)
+	}
+	return problems
+}
+
+func checkValue(path string, prop map[string]any, v any) []string {
+	want, _ := prop["type"].(string)
+	ok := true
+	switch want {
+	case "string":
+		_, ok = v.(string)
+	case "boolean":
+		_, ok = v.(bool)
+	case "number":
+		_, ok = v.(float64)
+	case "integer":
+		f, isNum := v.(float64)
+		ok = isNum && f == math.Trunc(f)
+	case "array":
+		items, isArr := v.([]any)
+		if !isArr {
+			ok = false
+			break
+		}
+		itemSchema, _ := prop["items"].(map[string]any)
+		problems := []string{}
+		for i, item := range items {
+			problems = append(problems, checkValue(fmt.Sprintf("%s[%d]", path, i), itemSchema, item)...)
+		}
+		return problems
+	case "object":
+		obj, isObj := v.(map[string]any)
+		if !isObj {
+			ok = false
+			break
+		}
+		problems := []string{}
+		for _, p := range validate(prop, obj) {
+			problems = append(problems, path+": "+p)
+		}
+		return problems
+	}
+	if !ok {
+		return []string{fmt.Sprintf("parameter %q must be of type %s, got %s", path, want, jsonType(v))}
+	}
+	if enum, ok := prop["enum"].([]any); ok {
+		for _, e := range enum {
+			if e == v {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("parameter %q must be one of %v, got %v", path, enum, v)}
+	}
+	return nil
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func describeParams(schema map[string]any) string {
+	properties, _ := schema["properties"].(map[string]any)
+	required, _ := schema["required"].([]string)
+	isRequired := map[string]bool{}
+	for _, r := range required {
+		isRequired[r] = true
+	}
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sb := strings.Builder{}
+	for _, name := range names {
+		prop, _ := properties[name].(map[string]any)
+		req := "optional"
+		if isRequired[name] {
+			req = "required"
+		}
+		fmt.Fprintf(&sb, "- %s (%v, %s): %v\n", name, prop["type"], req, prop["description"])
+	}
+	return sb.String()
+}
@@ -0,0 +1,249 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single pattern of a .gitignore file.
+type ignoreRule struct {
+	base     string // directory of the .gitignore, relative to the repository root
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parseGitignore(file string, base string) []ignoreRule {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	rules := []ignoreRule{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "." {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, r.base+"/")
+	}
+	if r.anchored {
+		return globMatch(r.pattern, rel)
+	}
+	return globMatch(r.pattern, path.Base(rel))
+}
+
+// globMatch matches a slash separated path against a pattern, where **
+// matches any number of path segments.
+func globMatch(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := range len(name) + 1 {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchesAny reports whether rel matches one of the glob patterns.
+// Patterns without a slash are matched against the base name.
+func matchesAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if !strings.Contains(p, "/") {
+			if globMatch(p, path.Base(rel)) {
+				return true
+			}
+			continue
+		}
+		if globMatch(strings.TrimPrefix(p, "./"), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// alwaysIgnored are directories that are skipped in every walk. .sous
+// holds the sessions and settings of sous itself, past conversations must
+// not end up in the context again.
+var alwaysIgnored = []string{".git", ".sous"}
+
+// ignoreDirs returns the directories the registry of the current tool call
+// wants skipped.
+func ignoreDirs(ctx context.Context) []string {
+	info, ok := ctx.Value(callKey{}).(callInfo)
+	if !ok {
+		return nil
+	}
+	return info.registry.Ignore
+}
+
+// walkTree walks root depth first in lexical order and calls fn for every
+// entry that is not ignored by a .gitignore file. rel is the slash
+// separated path relative to root. maxDepth limits the depth of the walk,
+// entries directly inside root have depth 1. A maxDepth <= 0 means no limit.
+func walkTree(
+	root string,
+	maxDepth int,
+	ignoreDirs []string,
+	fn func(p string, rel string, d fs.DirEntry, depth int) error,
+) error {
+	// rules and the paths they are matched against are relative to the
+	// repository, so the .gitignore files above root apply as well
+	repo, prefix := repoRoot(root)
+	rules := map[string][]ignoreRule{}
+	rules["."] = parseGitignore(filepath.Join(repo, ".gitignore"), ".")
+	if prefix != "." {
+		dir := "."
+		for _, part := range strings.Split(prefix, "/") {
+			dir = path.Join(dir, part)
+			rules[dir] = parseGitignore(filepath.Join(repo, filepath.FromSlash(dir), ".gitignore"), dir)
+		}
+	}
+	skip := append(append([]string{}, alwaysIgnored...), ignoreDirs...)
+
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			// unreadable entries are skipped
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if d.IsDir() && matchesAny(skip, rel) {
+			return filepath.SkipDir
+		}
+		if ignored(rules, path.Join(prefix, rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		depth := strings.Count(rel, "/") + 1
+		if err := fn(p, rel, d, depth); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if maxDepth > 0 && depth >= maxDepth {
+				return filepath.SkipDir
+			}
+			dir := path.Join(prefix, rel)
+			rules[dir] = parseGitignore(filepath.Join(p, ".gitignore"), dir)
+		}
+		return nil
+	})
+}
+
+// repoRoot returns the nearest directory at or above root that holds a
+// .git entry and the slash separated path of root relative to it. Outside
+// of a repository it returns root itself.
+func repoRoot(root string) (string, string) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return root, "."
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			rel, err := filepath.Rel(dir, abs)
+			if err != nil {
+				return root, "."
+			}
+			return dir, filepath.ToSlash(rel)
+		}
+		if filepath.Dir(dir) == dir {
+			return root, "."
+		}
+	}
+}
+
+func ignored(rules map[string][]ignoreRule, rel string, isDir bool) bool {
+	result := false
+	// rules of outer directories come first, inner ones may override them
+	dirs := []string{"."}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+	for _, dir := range dirs {
+		for _, r := range rules[dir] {
+			if r.match(rel, isDir) {
+				result = !r.negate
+			}
+		}
+	}
+	return result
+}
+
+// isBinary guesses whether data is binary by looking for NUL bytes at the
+// start of the content.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	for _, b := range data {
+		if b == 0 {
+			return true
+		}
+	}
+	return false
+}
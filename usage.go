@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/moritz-tiesler/sous/client"
+	"github.com/moritz-tiesler/sous/usage"
+)
+
+// addUsage records the usage of a request. A failed request is only
+// counted if the backend reported tokens for it.
+func (a *Agent) addUsage(u client.Usage, err error) {
+	if err != nil && u.PromptTokens+u.CompletionTokens == 0 {
+		return
+	}
+	a.usage.Add(u)
+}
+
+// showUsage prints the usage of the last turn and of the session, and per
+// model if more than one answered, e.g. after failing over.
+func (a *Agent) showUsage() {
+	session := a.usage.Session()
+	if session.Requests == 0 {
+		PrintAction("No requests yet\n")
+		return
+	}
+	if turn := a.usage.Turn(); turn.Requests > 0 {
+		PrintAction("Last turn: %s\n", formatTotals(turn))
+	}
+	PrintAction("Session:   %s\n", formatTotals(session))
+	if models := a.usage.Models(); len(models) > 1 {
+		for _, m := range models {
+			PrintAction("  %s: %s\n", m.Model, formatTotals(m.Totals))
+		}
+	}
+}
+
+// printSessionUsage prints the usage of the session when it ends.
+func (a *Agent) printSessionUsage() {
+	if a.usage == nil || a.usage.Session().Requests == 0 {
+		return
+	}
+	PrintAction("Session usage: %s\n", formatTotals(a.usage.Session()))
+}
+
+func formatTotals(t usage.Totals) string {
+	requests := "requests"
+	if t.Requests == 1 {
+		requests = "request"
+	}
+	s := fmt.Sprintf("%d %s, %d prompt tokens", t.Requests, requests, t.PromptTokens)
+	if t.CachedTokens > 0 {
+		s += fmt.Sprintf(" (%d cached)", t.CachedTokens)
+	}
+	s += fmt.Sprintf(", %d completion tokens, %s", t.CompletionTokens, t.Latency.Round(100*time.Millisecond))
+	if t.Priced() {
+		s += fmt.Sprintf(", $%.4f", t.Cost)
+		if t.Unpriced > 0 {
+			s += fmt.Sprintf(" (%d without a price)", t.Unpriced)
+		}
+	}
+	return s
+}
@@ -0,0 +1,121 @@
+package usage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/moritz-tiesler/sous/client"
+	"github.com/moritz-tiesler/sous/config"
+)
+
+// Totals sums up the usage of a number of requests.
+type Totals struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	CachedTokens     int
+	Latency          time.Duration
+	// Cost is the price of the requests in USD. Unpriced counts the
+	// requests to models without a price, they are not part of Cost.
+	Cost     float64
+	Unpriced int
+}
+
+// Tokens is the number of prompt and completion tokens.
+func (t Totals) Tokens() int {
+	return t.PromptTokens + t.CompletionTokens
+}
+
+// Priced reports whether the cost of at least one request is known.
+func (t Totals) Priced() bool {
+	return t.Requests > t.Unpriced
+}
+
+func (t *Totals) add(u client.Usage, cost float64, priced bool) {
+	t.Requests++
+	t.PromptTokens += u.PromptTokens
+	t.CompletionTokens += u.CompletionTokens
+	t.CachedTokens += u.CachedTokens
+	t.Latency += u.Latency
+	t.Cost += cost
+	if !priced {
+		t.Unpriced++
+	}
+}
+
+// ModelTotals are the totals of the requests answered by one model.
+type ModelTotals struct {
+	Model string
+	Totals
+}
+
+// Tracker aggregates the usage of the requests of a session, for the
+// session as a whole, for the current turn of the user and per model.
+type Tracker struct {
+	prices  map[string]config.Price
+	session Totals
+	turn    Totals
+	models  map[string]*Totals
+}
+
+func NewTracker(prices map[string]config.Price) *Tracker {
+	return &Tracker{
+		prices: prices,
+		models: map[string]*Totals{},
+	}
+}
+
+// StartTurn resets the totals of the turn.
+func (t *Tracker) StartTurn() {
+	t.turn = Totals{}
+}
+
+// Add records the usage of a request.
+func (t *Tracker) Add(u client.Usage) {
+	cost, priced := t.Cost(u)
+	t.session.add(u, cost, priced)
+	t.turn.add(u, cost, priced)
+	m, ok := t.models[u.Model]
+	if !ok {
+		m = &Totals{}
+		t.models[u.Model] = m
+	}
+	m.add(u, cost, priced)
+}
+
+// Cost returns the price of a request in USD and whether the model has a
+// price.
+func (t *Tracker) Cost(u client.Usage) (float64, bool) {
+	p, ok := t.prices[u.Model]
+	if !ok {
+		return 0, false
+	}
+	cached := p.Cached
+	if cached == 0 {
+		cached = p.Input
+	}
+	cost := float64(u.PromptTokens-u.CachedTokens)*p.Input +
+		float64(u.CachedTokens)*cached +
+		float64(u.CompletionTokens)*p.Output
+	return cost / 1e6, true
+}
+
+// Session returns the totals of the session.
+func (t *Tracker) Session() Totals {
+	return t.session
+}
+
+// Turn returns the totals of the current, or else the last, turn.
+func (t *Tracker) Turn() Totals {
+	return t.turn
+}
+
+// Models returns the totals of every model that answered, ordered by name.
+func (t *Tracker) Models() []ModelTotals {
+	models := make([]ModelTotals, 0, len(t.models))
+	for name, totals := range t.models {
+		models = append(models, ModelTotals{Model: name, Totals: *totals})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Model < models[j].Model })
+	return models
+}